@@ -1,62 +1,163 @@
 package main
 
 import (
-	"backend/internal/messaging"
-	"backend/internal/vector"
+	"backend/internal/certs"
+	"backend/internal/config"
+	"backend/internal/gateway"
+	"backend/internal/logging"
 
+	"crypto/tls"
 	"flag"
 	"log"
 	"os"
 	"os/signal"
-	"path"
 	"sync"
+	"syscall"
 )
 
+func fatal(logger *logging.Logger, msg string, err error) {
+	logger.Error(msg, "err", err)
+	os.Exit(1)
+}
+
 func main() {
-	directory := flag.String("d", ".", "base data directory")
-	flag.Parse()
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.LookupEnv)
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fatal(logging.Default(), "Failed to load configuration", err)
+	}
+
+	logger := cfg.Logger()
+	logging.SetDefault(logger)
+
+	// Whatever still uses the standard logger ends up in the same place.
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelWarn))
+
+	systemLog := logger.With("component", "system")
+
+	// Without a key or a password, both services are open to anyone.
+	authenticator, err := cfg.Authenticator()
+	if err != nil {
+		fatal(systemLog, "Failed to set up authentication", err)
+	}
 
 	var wg sync.WaitGroup
 	stop := make(chan struct{})
+	// Holds a pending compaction, so that a signal arriving while one runs
+	// asks for one more rather than blocking the signal loop.
+	compact := make(chan struct{}, 1)
+
+	reloader, err := cfg.Reloader(logger)
+	if err != nil {
+		fatal(systemLog, "Failed to load certificate", err)
+	}
+
+	// Renewed certificates are picked up without dropping anyone.
+	var tlsConfig *tls.Config
+	if reloader != nil {
+		tlsConfig = reloader.Config()
+		go reloader.Watch(certs.PollInterval, stop)
+	}
+
+	vectorStore := cfg.VectorStore(stop, authenticator, tlsConfig, logger)
+	messageStore := cfg.MessageStore(stop, compact, authenticator, tlsConfig, logger)
 
 	processes := []func(){
 		func() {
 			defer wg.Done()
-
-			store := vector.Store{
-				Directory: path.Join(*directory, "vector"),
-				Stop:      stop}
-
-			store.Serve(9200)
+			vectorStore.Serve(cfg.VectorPort)
 		},
 
 		func() {
 			defer wg.Done()
-
-			store := messaging.Store{
-				Directory: path.Join(*directory, "message"),
-				Stop:      stop}
-
-			store.Serve(9300)
+			messageStore.Serve(cfg.MessagePort)
 		},
 	}
 
+	if cfg.Gateway != "" {
+		processes = []func(){
+			func() {
+				defer wg.Done()
+
+				service, err := messageStore.Open()
+				if err != nil {
+					fatal(systemLog, "Failed to create message store", err)
+				}
+
+				running := make(chan struct{})
+				go func() {
+					service.Run()
+					close(running)
+				}()
+
+				g := gateway.Gateway{
+					Vector:          vectorStore.Handler(),
+					Message:         service.Handler(),
+					Static:          cfg.Static,
+					Auth:            authenticator,
+					ShutdownTimeout: cfg.ShutdownTimeout,
+					TLS:             tlsConfig,
+					Redirect:        cfg.TLS.Redirect,
+					Log:             logger}
+
+				g.Serve(cfg.Gateway, stop)
+
+				<-running
+				service.Close()
+			},
+		}
+	}
+
 	wg.Add(len(processes))
 
 	for _, process := range processes {
 		go process()
 	}
 
+	// SIGTERM is how docker stop and orchestrators ask, SIGINT is Ctrl-C.
 	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-
-	<-interrupt
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+
+	// SIGUSR1 compacts the message logs without stopping the system.
+	compaction := make(chan os.Signal, 1)
+	signal.Notify(compaction, syscall.SIGUSR1)
+
+	// SIGHUP reloads the TLS certificate.
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+running:
+	for {
+		select {
+		case <-compaction:
+			select {
+			case compact <- struct{}{}:
+				systemLog.Info("Compacting message logs")
+			default:
+				systemLog.Info("Compaction already pending")
+			}
+		case <-hangup:
+			if reloader == nil {
+				break
+			}
+			if err := reloader.Reload(); err != nil {
+				systemLog.Error("Failed to reload certificate", "err", err)
+			} else {
+				systemLog.Info("Reloaded certificate")
+			}
+		case <-interrupt:
+			break running
+		}
+	}
 
-	log.Println("Stopping system...")
+	systemLog.Info("Stopping system")
 
 	close(stop)
 
 	wg.Wait()
 
-	log.Println("Stopped.")
+	systemLog.Info("Stopped")
 }
@@ -0,0 +1,52 @@
+// Command token mints access tokens for the vector and message services.
+package main
+
+import (
+	"backend/internal/auth"
+	"backend/internal/messaging"
+
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+func main() {
+	keyFile := flag.String("auth-key", "", "file holding the key the services were started with")
+	subject := flag.String("subject", "", "who the token is for")
+	room := flag.String("room", "", "the only room the token opens, or every room if empty")
+	role := flag.String("role", "", "owner, editor or viewer, or the services' default role if empty")
+	ttl := flag.Duration("ttl", 24*time.Hour, "how long the token is valid, or forever if 0")
+	flag.Parse()
+
+	if *keyFile == "" {
+		log.Fatal("An -auth-key is required.")
+	}
+
+	if *role != "" {
+		if _, err := messaging.ParseRole(*role); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *room != "" && !messaging.ValidRoomId(*room) {
+		log.Fatalf("Invalid room id '%s'.", *room)
+	}
+
+	key, err := auth.LoadKey(*keyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	claims := auth.Claims{Subject: *subject, Room: *room, Role: *role}
+	if *ttl > 0 {
+		claims.Expires = time.Now().Add(*ttl).Unix()
+	}
+
+	token, err := auth.NewHMAC(key).Sign(claims)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(token)
+}
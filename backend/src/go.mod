@@ -8,4 +8,5 @@ require (
 	github.com/google/go-cmp v0.5.0
 	github.com/gorilla/websocket v1.4.2
 	golang.org/x/net v0.0.0-20200625001655-4c5254603344 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )
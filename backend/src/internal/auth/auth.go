@@ -0,0 +1,102 @@
+// Package auth authenticates requests to the vector and message services.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	ErrMissing = errors.New("no credentials")
+	ErrInvalid = errors.New("invalid credentials")
+	ErrExpired = errors.New("expired token")
+)
+
+// Claims are what a request is allowed, once authenticated.
+type Claims struct {
+	// Who the token was issued to, for the logs.
+	Subject string `json:"sub,omitempty"`
+
+	// The only room the token opens, or "" for every room.
+	Room string `json:"room,omitempty"`
+
+	// The role on the board, or "" for the service's default.
+	Role string `json:"role,omitempty"`
+
+	// Unix time after which the token is refused, or 0 if it never expires.
+	Expires int64 `json:"exp,omitempty"`
+}
+
+// AllowsRoom reports whether the claims open the given room.
+func (c *Claims) AllowsRoom(room string) bool {
+	return c.Room == "" || c.Room == room
+}
+
+func (c *Claims) expired(now time.Time) bool {
+	return c.Expires != 0 && now.Unix() > c.Expires
+}
+
+// An Authenticator establishes the claims of a request.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Claims, error)
+}
+
+// TokenFrom returns the credentials a request presents: a bearer token, the
+// password of basic auth or, since browsers cannot set headers on websockets,
+// ?token=.
+func TokenFrom(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+
+	return r.URL.Query().Get("token")
+}
+
+type contextKey struct{}
+
+// WithClaims returns a context carrying the claims of its request.
+func WithClaims(ctx context.Context, claims *Claims) context.Context {
+	return context.WithValue(ctx, contextKey{}, claims)
+}
+
+// ClaimsFrom returns the claims Middleware found for the request, or nil if
+// the service does not authenticate.
+func ClaimsFrom(ctx context.Context) *Claims {
+	claims, _ := ctx.Value(contextKey{}).(*Claims)
+	return claims
+}
+
+// Middleware refuses requests the authenticator does not accept, and passes
+// the claims of the rest on in their context. A nil authenticator lets
+// everything through.
+func Middleware(authenticator Authenticator) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if authenticator == nil {
+			return next
+		}
+
+		// Browsers prompt for a password, as they did behind the proxy.
+		challenge := `Bearer realm="doodleopia"`
+		if _, ok := authenticator.(*Password); ok {
+			challenge = `Basic realm="doodleopia"`
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, err := authenticator.Authenticate(r)
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", challenge)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(WithClaims(r.Context(), claims)))
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package auth
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHMAC(t *testing.T) {
+	signer := NewHMAC([]byte("a key for testing tokens"))
+	now := time.Now()
+	signer.now = func() time.Time { return now }
+
+	token, err := signer.Sign(Claims{Subject: "ada", Room: "board", Role: "editor", Expires: now.Add(time.Hour).Unix()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("Want the token verified; Got %v", err)
+	}
+	if claims.Subject != "ada" || claims.Role != "editor" || !claims.AllowsRoom("board") || claims.AllowsRoom("other") {
+		t.Errorf("Claims did not survive the round trip: %+v", claims)
+	}
+
+	other := NewHMAC([]byte("some other key entirely"))
+	if _, err := other.Verify(token); err != ErrInvalid {
+		t.Errorf("Other key: Want ErrInvalid; Got %v", err)
+	}
+
+	forged, _ := other.Sign(Claims{Role: "owner"})
+	if _, err := signer.Verify(forged[:len(forged)-43] + token[len(token)-43:]); err != ErrInvalid {
+		t.Errorf("Swapped signature: Want ErrInvalid; Got %v", err)
+	}
+
+	for _, malformed := range []string{"", "nodot", "a.b.c", "!!.!!"} {
+		if _, err := signer.Verify(malformed); err != ErrInvalid {
+			t.Errorf("'%s': Want ErrInvalid; Got %v", malformed, err)
+		}
+	}
+
+	signer.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := signer.Verify(token); err != ErrExpired {
+		t.Errorf("Want ErrExpired; Got %v", err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	signer := NewHMAC([]byte("a key for testing tokens"))
+	token, _ := signer.Sign(Claims{Subject: "ada"})
+
+	var seen *Claims
+	handler := Middleware(signer)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = ClaimsFrom(r.Context())
+	}))
+
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+		want    int
+	}{
+		{"no token", func(r *http.Request) {}, http.StatusUnauthorized},
+		{"bearer", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }, http.StatusOK},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "token=" + token }, http.StatusOK},
+		{"bad token", func(r *http.Request) { r.URL.RawQuery = "token=x" + token }, http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		seen = nil
+		request := httptest.NewRequest("GET", "/", nil)
+		test.prepare(request)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		if recorder.Code != test.want {
+			t.Errorf("%s: Want status %d; Got %d", test.name, test.want, recorder.Code)
+		}
+		if test.want == http.StatusOK && (seen == nil || seen.Subject != "ada") {
+			t.Errorf("%s: Want the claims passed on; Got %+v", test.name, seen)
+		}
+	}
+
+	open := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	recorder := httptest.NewRecorder()
+	open.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if recorder.Code != http.StatusOK {
+		t.Errorf("Want everything let through without an authenticator; Got %d", recorder.Code)
+	}
+}
+
+func TestPassword(t *testing.T) {
+	password := &Password{Password: "open sesame", Claims: Claims{Role: "viewer"}}
+
+	request := httptest.NewRequest("GET", "/", nil)
+	request.SetBasicAuth("anyone", "open sesame")
+	if claims, err := password.Authenticate(request); err != nil || claims.Role != "viewer" {
+		t.Errorf("Want the password accepted; Got %v, %v", claims, err)
+	}
+
+	request = httptest.NewRequest("GET", "/?token=closed", nil)
+	if _, err := password.Authenticate(request); err != ErrInvalid {
+		t.Errorf("Want ErrInvalid; Got %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	Middleware(password)(http.NotFoundHandler()).ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if challenge := recorder.Header().Get("WWW-Authenticate"); challenge != `Basic realm="doodleopia"` {
+		t.Errorf("Want browsers asked for a password; Got '%s'", challenge)
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	file, err := ioutil.TempFile("", "auth-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString("  short\n")
+	file.Close()
+
+	if _, err := LoadKey(file.Name()); err == nil {
+		t.Errorf("Want a short key refused")
+	}
+
+	ioutil.WriteFile(file.Name(), []byte("0123456789abcdef0123\n"), 0600)
+	key, err := LoadKey(file.Name())
+	if err != nil || string(key) != "0123456789abcdef0123" {
+		t.Errorf("Want the key without its newline; Got '%s', %v", key, err)
+	}
+}
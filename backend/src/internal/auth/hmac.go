@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HMAC issues and checks tokens of the form payload.signature, where the
+// payload is the JSON encoded Claims and the signature its HMAC-SHA256, both
+// base64url encoded.
+type HMAC struct {
+	key []byte
+	now func() time.Time
+}
+
+func NewHMAC(key []byte) *HMAC {
+	return &HMAC{key: key, now: time.Now}
+}
+
+var encoding = base64.RawURLEncoding
+
+func (h *HMAC) sign(payload string) string {
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(payload))
+	return encoding.EncodeToString(mac.Sum(nil))
+}
+
+// Sign issues a token carrying the claims.
+func (h *HMAC) Sign(claims Claims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	payload := encoding.EncodeToString(data)
+	return payload + "." + h.sign(payload), nil
+}
+
+// Verify returns the claims of a token this key signed that has not expired.
+func (h *HMAC) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, ErrInvalid
+	}
+
+	if !hmac.Equal([]byte(parts[1]), []byte(h.sign(parts[0]))) {
+		return nil, ErrInvalid
+	}
+
+	data, err := encoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrInvalid
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, ErrInvalid
+	}
+
+	if claims.expired(h.now()) {
+		return nil, ErrExpired
+	}
+
+	return &claims, nil
+}
+
+func (h *HMAC) Authenticate(r *http.Request) (*Claims, error) {
+	token := TokenFrom(r)
+	if token == "" {
+		return nil, ErrMissing
+	}
+	return h.Verify(token)
+}
+
+// Keys shorter than this are refused by LoadKey.
+const minimumKeySize = 16
+
+// LoadKey reads a signing key from a file, ignoring surrounding whitespace.
+func LoadKey(filename string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	key := bytes.TrimSpace(data)
+	if len(key) < minimumKeySize {
+		return nil, fmt.Errorf("Key in '%s' is shorter than %d bytes", filename, minimumKeySize)
+	}
+
+	return key, nil
+}
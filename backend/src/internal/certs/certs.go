@@ -0,0 +1,141 @@
+// Package certs serves TLS from a certificate and key on disk, reloading them
+// when they change so that renewals need no restart. Connections already
+// established, websockets included, carry on with the certificate they were
+// opened with.
+package certs
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"backend/internal/logging"
+)
+
+// How often Watch looks for a renewed certificate.
+const PollInterval = 10 * time.Second
+
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	log *logging.Logger
+
+	mutex    sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// NewReloader loads the certificate and key, failing if they do not load. A
+// nil log selects the default logger.
+func NewReloader(certFile, keyFile string, log *logging.Logger) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile, log: log.With("component", "certs", "cert", certFile)}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate and key again. On failure the previous
+// certificate stays in use.
+func (r *Reloader) Reload() error {
+	modified := r.lastModified()
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.cert = &cert
+	r.modified = modified
+
+	return nil
+}
+
+// lastModified is the later of the modification times of the two files.
+func (r *Reloader) lastModified() time.Time {
+	latest := time.Time{}
+	for _, name := range []string{r.certFile, r.keyFile} {
+		if info, err := os.Stat(name); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.cert, nil
+}
+
+// Config is a TLS configuration that always presents the latest certificate.
+func (r *Reloader) Config() *tls.Config {
+	return &tls.Config{GetCertificate: r.GetCertificate}
+}
+
+// Watch reloads the certificate whenever its files change, until stop is
+// closed.
+func (r *Reloader) Watch(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.mutex.RLock()
+			changed := r.lastModified().After(r.modified)
+			r.mutex.RUnlock()
+
+			if !changed {
+				break
+			}
+
+			if err := r.Reload(); err != nil {
+				r.log.Error("Failed to reload certificate", "err", err)
+			} else {
+				r.log.Info("Reloaded certificate")
+			}
+
+		case <-stop:
+			return
+		}
+	}
+}
+
+// ListenAndServe serves HTTPS if the server has a TLS configuration, and
+// plain HTTP if not.
+func ListenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// Redirect sends plain HTTP requests to the same URL over HTTPS, on the given
+// port or, if it is empty, the default one.
+func Redirect(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.Trim(r.Host, "[]")
+		}
+
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+
+		target := "https://" + host + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
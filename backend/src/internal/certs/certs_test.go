@@ -0,0 +1,164 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+// writeCertificate writes a self-signed certificate for the given name.
+func writeCertificate(t *testing.T, dir string, name string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{name},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+
+	ioutil.WriteFile(path.Join(dir, "cert.pem"), certPem, 0600)
+	ioutil.WriteFile(path.Join(dir, "key.pem"), keyPem, 0600)
+}
+
+func servedName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := NewReloader(path.Join(dir, "cert.pem"), path.Join(dir, "key.pem"), nil); err == nil {
+		t.Fatalf("Want an error without a certificate")
+	}
+
+	writeCertificate(t, dir, "first.example.com")
+	r, err := NewReloader(path.Join(dir, "cert.pem"), path.Join(dir, "key.pem"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.Watch(10*time.Millisecond, stop)
+
+	// A renewal is noticed without being asked.
+	later := time.Now().Add(time.Minute)
+	writeCertificate(t, dir, "second.example.com")
+	os.Chtimes(path.Join(dir, "cert.pem"), later, later)
+
+	for deadline := time.Now().Add(2 * time.Second); servedName(t, r) != "second.example.com"; {
+		if time.Now().After(deadline) {
+			t.Fatalf("Want the renewed certificate served; Got %s", servedName(t, r))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// A broken renewal leaves the last good certificate in place.
+	ioutil.WriteFile(path.Join(dir, "key.pem"), []byte("not a key"), 0600)
+	if err := r.Reload(); err == nil {
+		t.Errorf("Want an error reloading a broken key")
+	}
+	if name := servedName(t, r); name != "second.example.com" {
+		t.Errorf("Want the last good certificate kept; Got %s", name)
+	}
+}
+
+func TestServesTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	writeCertificate(t, dir, "boards.example.com")
+	r, err := NewReloader(path.Join(dir, "cert.pem"), path.Join(dir, "key.pem"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &http.Server{Handler: http.NotFoundHandler(), TLSConfig: r.Config()}
+	go server.ServeTLS(listener, "", "")
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	response, err := client.Get("https://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if name := response.TLS.PeerCertificates[0].Subject.CommonName; name != "boards.example.com" {
+		t.Errorf("Want the reloader's certificate served; Got %s", name)
+	}
+}
+
+func TestRedirect(t *testing.T) {
+	tests := []struct {
+		port string
+		host string
+		want string
+	}{
+		{"8443", "boards.example.com", "https://boards.example.com:8443/rooms/a?since=3"},
+		{"443", "boards.example.com:80", "https://boards.example.com/rooms/a?since=3"},
+		{"", "[::1]:8080", "https://[::1]/rooms/a?since=3"},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/rooms/a?since=3", nil)
+		request.Host = test.host
+		recorder := httptest.NewRecorder()
+		Redirect(test.port).ServeHTTP(recorder, request)
+
+		if got := recorder.Header().Get("Location"); recorder.Code != http.StatusMovedPermanently || got != test.want {
+			t.Errorf("Want a redirect to %s; Got %d %s", test.want, recorder.Code, got)
+		}
+	}
+}
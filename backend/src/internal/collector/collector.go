@@ -1,96 +1,456 @@
 package collector
 
 import (
-	"bytes"
-	"encoding/binary"
-	"io"
-	"log"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"backend/internal/logging"
 	message "backend/internal/message"
 )
 
+// Record is a message as stored by the collector. Sequence numbers increase
+// by one for every record written; records from logs written before they were
+// introduced have a Sequence of 0 and a zero Received time.
 type Record struct {
-	Size int64
-	Data []byte
+	Sequence uint64
+	Received time.Time
+	Data     []byte
 }
 
+// Collector appends messages to a log split into size-bounded segment files,
+// alongside an index of every ActionClear so playback can skip cleared history.
 type Collector struct {
-	Sink     chan *[]byte
-	writer   io.WriteCloser
-	reader   io.ReadSeeker
+	sink     chan *Record
+	flush    chan chan struct{}
 	done     chan struct{}
 	Finished chan struct{}
+
+	directory   string
+	segmentSize int64
+
+	log *logging.Logger
+
+	// The last sequence number handed out. Accessed atomically.
+	sequence uint64
+
+	// Held throughout Compact, one compaction at a time.
+	compacting sync.Mutex
+
+	// Shared by reads while they read segments, which they do without
+	// holding mutex, and held by Compact while it replaces segments.
+	files sync.RWMutex
+
+	// Guards everything below. Writes hold it exclusively, reads share it.
+	mutex      sync.RWMutex
+	segments   []int64
+	active     *os.File
+	activeSize int64
+	recovery   *Recovery
+	stopped    bool
+	index      *os.File
+	clears     []position
+	clientId   uint16
 }
 
-func NewCollector(writer io.WriteCloser, reader io.ReadSeeker) *Collector {
-	return &Collector{
-		Sink:     make(chan *[]byte),
-		Finished: make(chan struct{}),
-		done:     make(chan struct{}),
-		writer:   writer,
-		reader:   reader,
+// NewCollector opens the log in directory, creating it if necessary. A
+// segmentSize of zero selects DefaultSegmentSize, and a nil log the default
+// logger.
+func NewCollector(directory string, segmentSize int64, log *logging.Logger) (*Collector, error) {
+	log = log.With("component", "collector", "directory", directory)
+
+	if segmentSize <= 0 {
+		segmentSize = DefaultSegmentSize
+	}
+
+	if err := os.MkdirAll(directory, 0755); err != nil {
+		return nil, err
+	}
+
+	if err := migrateLegacy(directory); err != nil {
+		return nil, fmt.Errorf("Failed to migrate %s: %v", legacyName, err)
+	}
+
+	segments, err := listSegments(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) == 0 {
+		segments = []int64{0}
+	}
+
+	recovery, err := recoverSegment(directory, segments[len(segments)-1])
+	if err != nil {
+		return nil, fmt.Errorf("Failed to recover collector log: %v", err)
+	}
+
+	if recovery != nil {
+		log.Warn("Recovered damaged log", "records", recovery.DroppedRecords, "bytes", recovery.DroppedBytes,
+			"segment", segmentName(recovery.Segment), "offset", recovery.Offset, "reason", recovery.Reason)
+	}
+
+	s := &Collector{
+		sink:        make(chan *Record),
+		flush:       make(chan chan struct{}),
+		Finished:    make(chan struct{}),
+		done:        make(chan struct{}),
+		directory:   directory,
+		segmentSize: segmentSize,
+		log:         log,
+		segments:    segments,
+		recovery:    recovery,
+	}
+
+	if err := s.openActive(); err != nil {
+		return nil, err
+	}
+
+	// Never append records of the current format to an older segment.
+	if version, err := s.activeVersion(); err != nil {
+		s.active.Close()
+		return nil, err
+	} else if version < FormatVersion {
+		if err := s.roll(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.loadIndex(); err != nil {
+		s.active.Close()
+		return nil, err
 	}
+
+	if err := s.loadSequence(); err != nil {
+		s.active.Close()
+		s.index.Close()
+		return nil, err
+	}
+
+	if err := s.loadClientId(); err != nil {
+		s.active.Close()
+		s.index.Close()
+		return nil, err
+	}
+
+	return s, nil
 }
 
-func isClearMessage(input []byte) bool {
-	return message.GetRootAsMessage(input, 0).Action() == message.ActionClear
+func (s *Collector) openActive() error {
+	segment := s.segments[len(s.segments)-1]
+
+	active, err := os.OpenFile(path.Join(s.directory, segmentName(segment)), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := active.Stat()
+	if err != nil {
+		active.Close()
+		return err
+	}
+
+	s.active = active
+	s.activeSize = info.Size()
+
+	if s.activeSize == 0 {
+		n, err := s.active.Write(encodeHeader(FormatVersion))
+		s.activeSize = int64(n)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
 }
 
-func sinceLastClear(input [][]byte) [][]byte {
-	idx := len(input)
+func (s *Collector) activeVersion() (uint16, error) {
+	file, err := os.Open(path.Join(s.directory, segmentName(s.segments[len(s.segments)-1])))
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	version, _, err := readHeader(file)
+	return version, err
+}
+
+// Recovered reports the damaged tail that was dropped when the log was
+// opened, or nil if the log was intact.
+func (s *Collector) Recovered() *Recovery {
+	return s.recovery
+}
 
-	for {
-		if idx == 0 {
-			break
+// loadSequence finds the last sequence number written, searching backwards
+// from the newest segment.
+func (s *Collector) loadSequence() error {
+	for idx := len(s.segments) - 1; idx >= 0; idx-- {
+		last := uint64(0)
+		err := scanSegment(s.directory, s.segments[idx], 0, func(at position, record Record) error {
+			if record.Sequence > last {
+				last = record.Sequence
+			}
+			return nil
+		})
+		if err != nil {
+			return err
 		}
 
-		idx = idx - 1
+		if last > 0 {
+			s.sequence = last
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// LastSequence returns the sequence number of the most recently collected
+// record, or 0 if there is none.
+func (s *Collector) LastSequence() uint64 {
+	return atomic.LoadUint64(&s.sequence)
+}
+
+// loadIndex reads the sidecar index, rebuilding it if it is missing or stale.
+func (s *Collector) loadIndex() error {
+	clears, err := readIndex(s.directory)
+	if err != nil || !s.validIndex(clears) {
+		s.log.Info("Rebuilding index")
+		clears = nil
+	}
+
+	// Pick up clears that reached the log but not the index, such as when
+	// the process stopped between the two writes.
+	from := position{Segment: s.segments[0]}
+	indexed := len(clears) > 0
+	if indexed {
+		from = clears[len(clears)-1]
+	}
+
+	found := clears
+	err = s.scan(from, func(at position, record Record) error {
+		if (!indexed || at != from) && isClearMessage(record.Data) {
+			found = append(found, at)
+		}
+		// Picked up for loadClientId, which is left with what came
+		// before the last clear.
+		if id := clientIdOf(record.Data); id > s.clientId {
+			s.clientId = id
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
-		if isClearMessage(input[idx]) {
-			break
+	if clears == nil || len(found) != len(clears) {
+		if err := writeIndex(s.directory, found); err != nil {
+			return fmt.Errorf("Failed to write collector index: %v", err)
 		}
 	}
 
-	return input[idx:]
+	index, err := os.OpenFile(path.Join(s.directory, indexName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.index = index
+	s.clears = found
+
+	return nil
 }
 
-func (s *Collector) ReadSinceLastClear() ([][]byte, error) {
-	if messages, err := s.Read(); err != nil {
-		return make([][]byte, 0), err
-	} else {
-		return sinceLastClear(messages), nil
+// loadClientId reads the highest client id from beside the log, scanning the
+// whole log for it only if it was never recorded. loadIndex has seen the
+// records since the last clear, which a write may have left unrecorded.
+func (s *Collector) loadClientId() error {
+	recorded, ok, err := readClientId(s.directory)
+	if err != nil {
+		return err
 	}
+
+	if !ok {
+		err := s.scan(position{Segment: s.segments[0]}, func(at position, record Record) error {
+			if id := clientIdOf(record.Data); id > s.clientId {
+				s.clientId = id
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if recorded > s.clientId {
+		s.clientId = recorded
+	} else if !ok || s.clientId > recorded {
+		if err := writeClientId(s.directory, s.clientId); err != nil {
+			return fmt.Errorf("Failed to record the last client id: %v", err)
+		}
+	}
+
+	return nil
 }
 
-func (s *Collector) Read() ([][]byte, error) {
-	var messages [][]byte
+// LastClientId returns the highest client id of any record in the log,
+// including those compacted away, or 0 if there is none.
+func (s *Collector) LastClientId() uint16 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
-	if _, err := s.reader.Seek(0, 0); err != nil {
-		return messages, err
+	return s.clientId
+}
+
+func (s *Collector) validIndex(clears []position) bool {
+	known := make(map[int64]bool)
+	for _, segment := range s.segments {
+		known[segment] = true
 	}
 
-	for {
-		var size int64
-		if err := binary.Read(s.reader, binary.LittleEndian, &size); err != nil {
-			if err == io.EOF {
-				break
-			} else {
-				return messages, err
-			}
+	for _, p := range clears {
+		if !known[p.Segment] {
+			return false
 		}
-		bytes := make([]byte, size)
-		n, err := s.reader.Read(bytes)
+	}
+
+	if len(clears) == 0 {
+		return true
+	}
+
+	last := clears[len(clears)-1]
+	valid := false
+	err := scanSegment(s.directory, last.Segment, last.Offset, func(at position, record Record) error {
+		valid = isClearMessage(record.Data)
+		return errStopScan
+	})
+
+	return valid && err == errStopScan
+}
+
+var errStopScan = fmt.Errorf("Scan stopped.")
+
+// scan visits every record from the given position to the end of the log.
+func (s *Collector) scan(from position, fn func(position, Record) error) error {
+	for _, segment := range s.segments {
+		if segment < from.Segment {
+			continue
+		}
+
+		offset := int64(0)
+		if segment == from.Segment {
+			offset = from.Offset
+		}
+
+		if err := scanSegment(s.directory, segment, offset, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readBetween reads the records from one position up to another in the given
+// segments. It is called holding files but not mutex, so that writes carry
+// on past the end while it reads.
+func (s *Collector) readBetween(segments []int64, from, end position) ([]Record, error) {
+	var records []Record
+
+	for _, segment := range segments {
+		if segment < from.Segment {
+			continue
+		}
+
+		offset := int64(0)
+		if segment == from.Segment {
+			offset = from.Offset
+		}
+
+		until := int64(-1)
+		if segment == end.Segment {
+			until = end.Offset
+		}
+
+		err := scanSegmentUntil(s.directory, segment, offset, until, func(at position, record Record) error {
+			records = append(records, record)
+			return nil
+		})
 		if err != nil {
-			return messages, err
+			return records, err
 		}
-		if int64(n) != size {
-			return messages, err // TODO: return informative error insteal of nil
+	}
+
+	return records, nil
+}
+
+// end is the segments of the log and the position just past its last record,
+// or an offset of -1 once stopped, when nothing is appended any more and the
+// whole of the last segment is read. Called holding mutex.
+func (s *Collector) end() ([]int64, position) {
+	segments := append([]int64{}, s.segments...)
+
+	end := position{Segment: segments[len(segments)-1], Offset: s.activeSize}
+	if s.stopped {
+		end.Offset = -1
+	}
+	return segments, end
+}
+
+func isClearMessage(input []byte) (result bool) {
+	defer func() {
+		// Records from old or damaged logs may not be valid flatbuffers.
+		if err := recover(); err != nil {
+			result = false
 		}
+	}()
+
+	return message.GetRootAsMessage(input, 0).Action() == message.ActionClear
+}
+
+func clientIdOf(input []byte) (result uint16) {
+	defer func() {
+		if err := recover(); err != nil {
+			result = 0
+		}
+	}()
+
+	return message.GetRootAsMessage(input, 0).ClientId()
+}
+
+// ReadSinceLastClear returns the records from the most recent ActionClear
+// onwards, reading only the segments that follow it. Records written while it
+// reads are left for the next read.
+func (s *Collector) ReadSinceLastClear() ([]Record, error) {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
+	s.mutex.RLock()
+	from := position{Segment: s.segments[0]}
+	if len(s.clears) > 0 {
+		from = s.clears[len(s.clears)-1]
+	}
+	segments, end := s.end()
+	s.mutex.RUnlock()
 
-		messages = append(messages, bytes)
+	if records, err := s.readBetween(segments, from, end); err != nil {
+		return make([]Record, 0), err
+	} else {
+		return records, nil
 	}
+}
 
-	return messages, nil
+func (s *Collector) Read() ([]Record, error) {
+	s.files.RLock()
+	defer s.files.RUnlock()
+
+	s.mutex.RLock()
+	segments, end := s.end()
+	s.mutex.RUnlock()
+
+	return s.readBetween(segments, position{Segment: segments[0]}, end)
 }
 
 func messageOk(payload *[]byte) (result bool) {
@@ -111,24 +471,75 @@ func messageOk(payload *[]byte) (result bool) {
 	return true
 }
 
+// roll seals the active segment and starts the next one.
+func (s *Collector) roll() error {
+	if err := s.active.Close(); err != nil {
+		return err
+	}
+
+	s.segments = append(s.segments, s.segments[len(s.segments)-1]+1)
+
+	return s.openActive()
+}
+
+func (s *Collector) write(record *Record) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	frame := encodeFrame(*record)
+
+	if s.activeSize > segmentHeaderSize && s.activeSize+int64(len(frame)) > s.segmentSize {
+		if err := s.roll(); err != nil {
+			return fmt.Errorf("Failed to roll over segment: %v", err)
+		}
+	}
+
+	at := position{Segment: s.segments[len(s.segments)-1], Offset: s.activeSize}
+
+	started := time.Now()
+	n, err := s.active.Write(frame)
+	writeSeconds.Observe(time.Since(started).Seconds())
+	writtenBytes.Add(float64(n))
+	s.activeSize = s.activeSize + int64(n)
+	if err != nil {
+		return err
+	}
+
+	if isClearMessage(record.Data) {
+		if _, err := s.index.Write(encodeIndexEntry(at)); err != nil {
+			return fmt.Errorf("Failed to index clear: %v", err)
+		}
+		s.clears = append(s.clears, at)
+	}
+
+	// Once per client, the first time it writes.
+	if id := clientIdOf(record.Data); id > s.clientId {
+		if err := writeClientId(s.directory, id); err != nil {
+			return fmt.Errorf("Failed to record client id: %v", err)
+		}
+		s.clientId = id
+	}
+
+	return nil
+}
+
 func (s *Collector) Start() {
 	go func() {
 		for {
 			select {
-			case payload := <-s.Sink:
-				if messageOk(payload) == false {
-					break
-				}
-				size := int64(len(*payload))
-				buffer := new(bytes.Buffer)
-				if err := binary.Write(buffer, binary.LittleEndian, size); err != nil {
-					log.Printf("Failed to write size var: %v", err)
-					return
+			case record := <-s.sink:
+				if err := s.write(record); err != nil {
+					s.log.Error("Failed to write to log", "err", err)
+					writeErrors.Inc()
 				}
-				s.writer.Write(buffer.Bytes())
-				s.writer.Write(*payload)
+			case flushed := <-s.flush:
+				close(flushed)
 			case <-s.done:
-				s.writer.Close()
+				s.mutex.Lock()
+				s.active.Close()
+				s.index.Close()
+				s.stopped = true
+				s.mutex.Unlock()
 				close(s.Finished)
 				return
 			}
@@ -136,6 +547,63 @@ func (s *Collector) Start() {
 	}()
 }
 
+// Collect stamps a payload with the next sequence number and the time it was
+// received, and queues it to be written. It returns nil for payloads that
+// are not persisted: cursor movements and invalid flatbuffers. Collect is
+// meant to be called from a single goroutine, which keeps the sequence
+// numbers in the same order as the log.
+func (s *Collector) Collect(payload []byte, received time.Time) *Record {
+	if messageOk(&payload) == false {
+		return nil
+	}
+
+	record := &Record{
+		Sequence: atomic.AddUint64(&s.sequence, 1),
+		Received: received,
+		Data:     payload,
+	}
+
+	select {
+	case s.sink <- record:
+		return record
+	case <-s.done:
+		return nil
+	}
+}
+
+// Flush waits until every record collected so far has been written.
+func (s *Collector) Flush() {
+	flushed := make(chan struct{})
+	select {
+	case s.flush <- flushed:
+		<-flushed
+	case <-s.Finished:
+	}
+}
+
+// Responsive reports whether the writing goroutine answers within timeout. A
+// goroutine stuck on a write fails, as does a stopped collector.
+func (s *Collector) Responsive(timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	flushed := make(chan struct{})
+	select {
+	case s.flush <- flushed:
+	case <-s.Finished:
+		return fmt.Errorf("collector stopped")
+	case <-deadline.C:
+		return fmt.Errorf("collector did not answer within %v", timeout)
+	}
+
+	select {
+	case <-flushed:
+		return nil
+	case <-deadline.C:
+		return fmt.Errorf("collector did not answer within %v", timeout)
+	}
+}
+
 func (s *Collector) Stop() {
 	close(s.done)
 }
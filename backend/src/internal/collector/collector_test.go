@@ -2,8 +2,12 @@ package collector
 
 import (
 	"bytes"
-	"io"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path"
 	"testing"
+	"time"
 
 	"backend/internal/message"
 
@@ -11,47 +15,19 @@ import (
 	"github.com/google/go-cmp/cmp"
 )
 
-type WriterCloser struct {
-	buffer *bytes.Buffer
-}
-
-func (wc WriterCloser) Write(p []byte) (int, error) {
-	return wc.buffer.Write(p)
-}
-
-func (wc WriterCloser) Close() error {
-	return nil
-}
-
-type ReadSeeker struct {
-	buffer *bytes.Buffer
-	offset int64
-}
-
-func (rs *ReadSeeker) Read(p []byte) (int, error) {
-	length := int64(rs.buffer.Len())
-	if rs.offset >= length {
-		return 0, io.EOF
+func newTestCollector(t *testing.T, segmentSize int64) (*Collector, string) {
+	dir, err := ioutil.TempDir("", "collector-test")
+	if err != nil {
+		t.Fatal(err)
 	}
-	count := int64(len(p))
-	low := rs.offset
-	high := rs.offset + count
-	rs.offset = rs.offset + count
-	return copy(p, rs.buffer.Bytes()[low:high]), nil
-}
-
-func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
-	rs.offset = offset
-	return rs.offset, nil
-}
 
-func NewTestCollector() *Collector {
-	buffer := new(bytes.Buffer)
-	wc := WriterCloser{buffer}
-	rs := &ReadSeeker{buffer, 0}
-
-	return NewCollector(wc, rs)
+	collector, err := NewCollector(dir, segmentSize, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
 
+	return collector, dir
 }
 
 func buildMessage(clientId uint16, action message.Action, x, y float32) []byte {
@@ -87,11 +63,12 @@ func TestCollectorSinceClear(t *testing.T) {
 		messages[9],
 	}
 
-	collector := NewTestCollector()
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
 	collector.Start()
 
 	for idx := range messages {
-		collector.Sink <- &messages[idx]
+		collector.Collect(messages[idx], time.Now())
 	}
 
 	collector.Stop()
@@ -107,8 +84,8 @@ func TestCollectorSinceClear(t *testing.T) {
 	}
 
 	for idx, want := range wants {
-		if cmp.Equal(want, got[idx]) != true {
-			t.Fatalf("Record does not match expected: %s\n", cmp.Diff(want, got))
+		if cmp.Equal(want, got[idx].Data) != true {
+			t.Fatalf("Record does not match expected: %s\n", cmp.Diff(want, got[idx].Data))
 		}
 	}
 }
@@ -128,11 +105,12 @@ func TestCollectorValidMessages(t *testing.T) {
 		messages[3],
 	}
 
-	collector := NewTestCollector()
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
 	collector.Start()
 
 	for idx := range messages {
-		collector.Sink <- &messages[idx]
+		collector.Collect(messages[idx], time.Now())
 	}
 
 	collector.Stop()
@@ -148,31 +126,667 @@ func TestCollectorValidMessages(t *testing.T) {
 	}
 
 	for idx, want := range wants {
-		if cmp.Equal(want, got[idx]) != true {
-			t.Fatalf("Record does not match expected: %s\n", cmp.Diff(want, got))
+		if cmp.Equal(want, got[idx].Data) != true {
+			t.Fatalf("Record does not match expected: %s\n", cmp.Diff(want, got[idx].Data))
 		}
 	}
 }
 
 func TestExpectErrorIfInvalidMessages(t *testing.T) {
-	wants := [][]byte{
+	invalid := [][]byte{
 		{42, 50, 6, 9, 0},
 		{1, 2, 3},
 		{4, 5, 6},
 		{100, 0, 200, 0, 0},
 	}
 
-	collector := NewTestCollector()
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
+
+	collect(collector, invalid)
+
+	/* Invalid flatbuffers are refused by the collector and never reach the log. */
+	if got, err := collector.Read(); err != nil || len(got) != 0 {
+		t.Fatalf("Expected an empty log, Got %v (error: %v).", got, err)
+	}
+
+	/* Invalid bytes that do reach the log are reported, not skipped. */
+	if err := appendToSegment(dir, 0, invalid[0]); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := collector.Read()
+	if err == nil {
+		t.Fatalf("Expected a read error, but got none. Received: %v.", got)
+	}
+
+	if _, ok := err.(*CorruptError); !ok {
+		t.Fatalf("Expected a *CorruptError, Got %T: %v", err, err)
+	}
+}
+
+func appendToSegment(dir string, segment int64, content []byte) error {
+	file, err := os.OpenFile(path.Join(dir, segmentName(segment)), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	_, err = file.Write(content)
+	return err
+}
+
+func collect(collector *Collector, messages [][]byte) {
 	collector.Start()
 
-	for idx := range wants {
-		collector.Sink <- &wants[idx]
+	for idx := range messages {
+		collector.Collect(messages[idx], time.Now())
 	}
 
 	collector.Stop()
 	<-collector.Finished
+}
 
-	if got, err := collector.Read(); err == nil {
-		t.Fatalf("Expected a read error, but got none. Received: %v.", got)
+func checkMessages(t *testing.T, wants [][]byte, got []Record) {
+	if len(got) != len(wants) {
+		t.Fatalf("Expected %d messages, Got %d.", len(wants), len(got))
+	}
+
+	for idx, want := range wants {
+		if cmp.Equal(want, got[idx].Data) != true {
+			t.Fatalf("Record does not match expected: %s\n", cmp.Diff(want, got[idx].Data))
+		}
+	}
+}
+
+func TestCollectorSegments(t *testing.T) {
+	messages := [][]byte{}
+	for idx := 0; idx < 10; idx++ {
+		messages = append(messages, buildMessage(12345, message.ActionMove, float32(idx), float32(idx)))
+	}
+
+	// Small enough to hold only a couple of records per segment.
+	collector, dir := newTestCollector(t, 128)
+	defer os.RemoveAll(dir)
+
+	collect(collector, messages)
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(segments) < 2 {
+		t.Fatalf("Expected the log to roll over into several segments, Got %d.", len(segments))
+	}
+
+	got, err := collector.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	checkMessages(t, messages, got)
+}
+
+func TestCollectorIndexesClears(t *testing.T) {
+	messages := [][]byte{
+		buildMessage(12345, message.ActionDown, 60.1, 60.2),
+		buildMessage(12345, message.ActionClear, 100.5, 200.5),
+		buildMessage(12345, message.ActionDown, 160.1, 80.2),
+		buildMessage(12345, message.ActionMove, 150.5, 210.5),
+		buildMessage(12345, message.ActionClear, 100.5, 200.5),
+		buildMessage(12345, message.ActionDown, 160.1, 80.2),
+		buildMessage(12345, message.ActionUp, 160.1, 80.2),
+	}
+
+	wants := messages[4:]
+
+	collector, dir := newTestCollector(t, 128)
+	defer os.RemoveAll(dir)
+
+	collect(collector, messages)
+
+	clears, err := readIndex(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(clears) != 2 {
+		t.Fatalf("Expected 2 indexed clears, Got %d.", len(clears))
+	}
+
+	got, err := collector.ReadSinceLastClear()
+	if err != nil {
+		t.Fatalf("Got an unexpected ReadSinceLastClear() error: %v", err)
+	}
+
+	checkMessages(t, wants, got)
+
+	// A lost index is rebuilt from the segments when the log is reopened.
+	if err := os.Remove(path.Join(dir, indexName)); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewCollector(dir, 128, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = reopened.ReadSinceLastClear()
+	if err != nil {
+		t.Fatalf("Got an unexpected ReadSinceLastClear() error: %v", err)
+	}
+
+	checkMessages(t, wants, got)
+
+	collect(reopened, nil)
+}
+
+func TestCollectorMigratesLegacyLog(t *testing.T) {
+	messages := [][]byte{
+		buildMessage(12345, message.ActionDown, 60.1, 60.2),
+		buildMessage(12345, message.ActionUp, 200.5, 230.5),
+	}
+
+	dir, err := ioutil.TempDir("", "collector-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// Version 0 records: an int64 size followed by the payload.
+	legacy := new(bytes.Buffer)
+	for _, msg := range messages {
+		binary.Write(legacy, binary.LittleEndian, int64(len(msg)))
+		legacy.Write(msg)
+	}
+
+	if err := ioutil.WriteFile(path.Join(dir, legacyName), legacy.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	collector, err := NewCollector(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collect(collector, nil)
+
+	/* New records are never appended to a segment of an older format. */
+	if segments, err := listSegments(dir); err != nil || len(segments) != 2 {
+		t.Fatalf("Expected a new segment after the legacy log, Got %v (error: %v).", segments, err)
+	}
+
+	got, err := collector.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	checkMessages(t, messages, got)
+
+	/* Legacy records predate sequence numbers. */
+	for _, record := range got {
+		if record.Sequence != 0 || !record.Received.IsZero() {
+			t.Fatalf("Expected an unstamped legacy record, Got %+v.", record)
+		}
+	}
+}
+
+func TestCollectorRecoversTornTail(t *testing.T) {
+	messages := [][]byte{
+		buildMessage(12345, message.ActionDown, 60.1, 60.2),
+		buildMessage(12345, message.ActionMove, 100.5, 200.5),
+		buildMessage(12345, message.ActionUp, 200.5, 230.5),
+	}
+
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
+
+	collect(collector, messages)
+
+	/* Simulate a crash part way through writing the next record. */
+	torn := encodeFrame(Record{Sequence: 4, Data: buildMessage(12345, message.ActionDown, 1, 1)})
+	if err := appendToSegment(dir, 0, torn[:20]); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := NewCollector(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recovery := reopened.Recovered()
+	if recovery == nil {
+		t.Fatalf("Expected the torn record to be recovered.")
+	}
+
+	if recovery.DroppedRecords != 1 || recovery.DroppedBytes != 20 {
+		t.Fatalf("Expected 1 record (20 bytes) dropped, Got %d (%d bytes).", recovery.DroppedRecords, recovery.DroppedBytes)
+	}
+
+	more := buildMessage(12345, message.ActionDown, 10.5, 20.5)
+	collect(reopened, [][]byte{more})
+
+	got, err := reopened.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	checkMessages(t, append(messages, more), got)
+}
+
+func TestCollectorRecoversChecksumMismatch(t *testing.T) {
+	messages := [][]byte{
+		buildMessage(12345, message.ActionDown, 60.1, 60.2),
+		buildMessage(12345, message.ActionUp, 200.5, 230.5),
+	}
+
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
+
+	collect(collector, messages)
+
+	/* Flip the last byte of the last record's payload. */
+	pathname := path.Join(dir, segmentName(0))
+	content, err := ioutil.ReadFile(pathname)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content[len(content)-1] = content[len(content)-1] ^ 0xff
+	if err := ioutil.WriteFile(pathname, content, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := collector.Read(); err == nil {
+		t.Fatalf("Expected a checksum error, but got none.")
+	}
+
+	reopened, err := NewCollector(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collect(reopened, nil)
+
+	if recovery := reopened.Recovered(); recovery == nil || recovery.DroppedRecords != 1 {
+		t.Fatalf("Expected 1 record dropped, Got %+v.", recovery)
+	}
+
+	got, err := reopened.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	checkMessages(t, messages[:1], got)
+}
+
+func compactionMessages() [][]byte {
+	return [][]byte{
+		buildMessage(12345, message.ActionDown, 60.1, 60.2),
+		buildMessage(12345, message.ActionUp, 60.1, 60.2),
+		buildMessage(12345, message.ActionClear, 100.5, 200.5),
+		buildMessage(12345, message.ActionDown, 160.1, 80.2),
+		buildMessage(12345, message.ActionMove, 150.5, 210.5),
+		buildMessage(12345, message.ActionClear, 100.5, 200.5),
+		buildMessage(12345, message.ActionDown, 160.1, 80.2),
+		buildMessage(12345, message.ActionUp, 160.1, 80.2),
+	}
+}
+
+func TestCollectorCompact(t *testing.T) {
+	messages := compactionMessages()
+
+	collector, dir := newTestCollector(t, 128)
+	defer os.RemoveAll(dir)
+
+	collector.Start()
+	for idx := range messages {
+		collector.Collect(messages[idx], time.Now())
+	}
+	collector.Flush()
+
+	result, err := collector.Compact(0, "")
+	if err != nil {
+		t.Fatalf("Got an unexpected Compact() error: %v", err)
+	}
+
+	if result.Records != 5 {
+		t.Fatalf("Expected 5 records compacted away, Got %d.", result.Records)
+	}
+
+	/* The log keeps accepting writes after compaction. */
+	more := [][]byte{
+		buildMessage(12345, message.ActionClear, 100.5, 200.5),
+		buildMessage(12345, message.ActionDown, 1.5, 2.5),
+	}
+	for idx := range more {
+		collector.Collect(more[idx], time.Now())
+	}
+
+	collector.Stop()
+	<-collector.Finished
+
+	got, err := collector.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	checkMessages(t, append(messages[5:], more...), got)
+
+	got, err = collector.ReadSinceLastClear()
+	if err != nil {
+		t.Fatalf("Got an unexpected ReadSinceLastClear() error: %v", err)
+	}
+
+	checkMessages(t, more, got)
+
+	/* The rewritten index survives reopening the log. */
+	reopened, err := NewCollector(dir, 128, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collect(reopened, nil)
+
+	if len(reopened.clears) != 2 {
+		t.Fatalf("Expected 2 indexed clears after reopening, Got %d.", len(reopened.clears))
+	}
+}
+
+func TestCollectorCompactTwice(t *testing.T) {
+	messages := compactionMessages()
+
+	/* One segment, so that both retained clears end up in the same one. */
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
+
+	collector.Start()
+	defer func() {
+		collector.Stop()
+		<-collector.Finished
+	}()
+
+	for idx := range messages {
+		collector.Collect(messages[idx], time.Now())
+	}
+	collector.Flush()
+
+	/* The second run finds the boundary clear at the start of its segment. */
+	for run := 1; run <= 2; run++ {
+		if _, err := collector.Compact(1, ""); err != nil {
+			t.Fatalf("Run %d: Got an unexpected Compact() error: %v", run, err)
+		}
+
+		got, err := collector.ReadSinceLastClear()
+		if err != nil {
+			t.Fatalf("Run %d: Got an unexpected ReadSinceLastClear() error: %v", run, err)
+		}
+
+		checkMessages(t, messages[5:], got)
+
+		got, err = collector.Read()
+		if err != nil {
+			t.Fatalf("Run %d: Got an unexpected Read() error: %v", run, err)
+		}
+
+		checkMessages(t, messages[2:], got)
+	}
+}
+
+func TestCollectorCompactArchive(t *testing.T) {
+	messages := compactionMessages()
+
+	collector, dir := newTestCollector(t, 128)
+	defer os.RemoveAll(dir)
+
+	collector.Start()
+	for idx := range messages {
+		collector.Collect(messages[idx], time.Now())
+	}
+
+	defer func() {
+		collector.Stop()
+		<-collector.Finished
+	}()
+
+	collector.Flush()
+
+	/* Retaining one previous clear keeps the board before the last clear. */
+	if _, err := collector.Compact(1, path.Join(dir, "archive")); err != nil {
+		t.Fatalf("Got an unexpected Compact() error: %v", err)
+	}
+
+	got, err := collector.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	checkMessages(t, messages[2:], got)
+
+	if _, err := collector.Compact(0, path.Join(dir, "archive")); err != nil {
+		t.Fatalf("Got an unexpected Compact() error: %v", err)
+	}
+
+	got, err = collector.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	checkMessages(t, messages[5:], got)
+
+	/* Everything compacted away is in the archive, in order. */
+	archived := []Record{}
+	segments, err := listSegments(path.Join(dir, "archive"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, segment := range segments {
+		err := scanSegment(path.Join(dir, "archive"), segment, 0, func(at position, record Record) error {
+			archived = append(archived, record)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	checkMessages(t, messages[:5], archived)
+}
+
+func TestCollectorSequences(t *testing.T) {
+	messages := [][]byte{
+		buildMessage(12345, message.ActionDown, 60.1, 60.2),
+		buildMessage(12345, message.ActionCursor, 80.5, 90.5),
+		buildMessage(12345, message.ActionUp, 200.5, 230.5),
+	}
+
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
+
+	received := time.Date(2020, 5, 17, 12, 30, 0, 500, time.UTC)
+
+	collector.Start()
+
+	stamped := []*Record{}
+	for idx := range messages {
+		stamped = append(stamped, collector.Collect(messages[idx], received))
+	}
+
+	collector.Stop()
+	<-collector.Finished
+
+	/* Cursor messages are not persisted, so they are not given a sequence number. */
+	if stamped[1] != nil {
+		t.Fatalf("Expected no record for a cursor message, Got %+v.", stamped[1])
+	}
+
+	if stamped[0].Sequence != 1 || stamped[2].Sequence != 2 {
+		t.Fatalf("Expected sequence numbers 1 and 2, Got %d and %d.", stamped[0].Sequence, stamped[2].Sequence)
+	}
+
+	got, err := collector.Read()
+	if err != nil {
+		t.Fatalf("Got an unexpected Read() error: %v", err)
+	}
+
+	for idx, record := range got {
+		if record.Sequence != uint64(idx+1) {
+			t.Fatalf("Expected sequence %d, Got %d.", idx+1, record.Sequence)
+		}
+
+		if !record.Received.Equal(received) {
+			t.Fatalf("Expected receive time %v, Got %v.", received, record.Received)
+		}
+	}
+
+	/* Sequence numbers carry on from the log when it is reopened. */
+	reopened, err := NewCollector(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last := reopened.LastSequence(); last != 2 {
+		t.Fatalf("Expected last sequence 2 after reopening, Got %d.", last)
+	}
+
+	reopened.Start()
+	record := reopened.Collect(messages[0], time.Now())
+	reopened.Stop()
+	<-reopened.Finished
+
+	if record.Sequence != 3 {
+		t.Fatalf("Expected sequence 3 after reopening, Got %d.", record.Sequence)
+	}
+}
+
+func TestCollectorResponsive(t *testing.T) {
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
+
+	if err := collector.Responsive(50 * time.Millisecond); err == nil {
+		t.Errorf("Want an error before the collector starts.")
+	}
+
+	collector.Start()
+
+	if err := collector.Responsive(time.Second); err != nil {
+		t.Errorf("Got an unexpected error from a running collector: %v", err)
+	}
+
+	collector.Stop()
+	<-collector.Finished
+
+	if err := collector.Responsive(time.Second); err == nil {
+		t.Errorf("Want an error once the collector stops.")
+	}
+}
+
+func TestCollectorReadsWhileWriting(t *testing.T) {
+	collector, dir := newTestCollector(t, 512)
+	defer os.RemoveAll(dir)
+
+	collector.Start()
+
+	reading := make(chan error)
+	go func() {
+		defer close(reading)
+		for idx := 0; idx < 200; idx++ {
+			if _, err := collector.ReadSinceLastClear(); err != nil {
+				reading <- err
+				return
+			}
+			if _, err := collector.Compact(0, ""); err != nil {
+				reading <- err
+				return
+			}
+		}
+	}()
+
+	/* Reads and compactions neither hold up writes nor see half of one. */
+	for idx := 0; idx < 2000; idx++ {
+		action := message.ActionMove
+		if idx%50 == 0 {
+			action = message.ActionClear
+		}
+		collector.Collect(buildMessage(1, action, float32(idx), 0), time.Now())
+	}
+
+	for err := range reading {
+		t.Errorf("Got an unexpected error: %v", err)
+	}
+
+	collector.Stop()
+	<-collector.Finished
+
+	got, err := collector.ReadSinceLastClear()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 50 {
+		t.Errorf("Expected the 50 records since the last clear, Got %d.", len(got))
+	}
+}
+
+func TestCollectorLastClientId(t *testing.T) {
+	collector, dir := newTestCollector(t, 0)
+	defer os.RemoveAll(dir)
+
+	collector.Start()
+	for _, clientId := range []uint16{3, 9, 5} {
+		collector.Collect(buildMessage(clientId, message.ActionDown, 1, 1), time.Now())
+	}
+	collector.Collect(buildMessage(0, message.ActionClear, 0, 0), time.Now())
+	collector.Stop()
+	<-collector.Finished
+
+	if last := collector.LastClientId(); last != 9 {
+		t.Fatalf("Expected last client id 9, Got %d.", last)
+	}
+
+	/* Carried over when reopened, from beside the log or, failing that, the log itself. */
+	for _, lost := range []bool{false, true} {
+		if lost {
+			os.Remove(path.Join(dir, clientIdName))
+		}
+
+		reopened, err := NewCollector(dir, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reopened.Start()
+		reopened.Stop()
+		<-reopened.Finished
+
+		if last := reopened.LastClientId(); last != 9 {
+			t.Fatalf("Expected last client id 9 after reopening (lost=%v), Got %d.", lost, last)
+		}
+	}
+
+	/* Compaction keeps it, though the client's records go. */
+	compacted, err := NewCollector(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compacted.Start()
+	if _, err := compacted.Compact(0, ""); err != nil {
+		t.Fatal(err)
+	}
+	compacted.Stop()
+	<-compacted.Finished
+
+	reopened, err := NewCollector(dir, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reopened.Start()
+	reopened.Stop()
+	<-reopened.Finished
+
+	if last := reopened.LastClientId(); last != 9 {
+		t.Fatalf("Expected last client id 9 after compacting, Got %d.", last)
 	}
 }
@@ -0,0 +1,328 @@
+package collector
+
+import (
+	"fmt"
+	"os"
+	"path"
+)
+
+// Compaction reports the outcome of Collector.Compact.
+type Compaction struct {
+	// Segments that were removed from the log entirely.
+	Segments int
+
+	// Records and bytes removed from the log.
+	Records int
+	Bytes   int64
+
+	// Whether the removed history was moved into the archive directory.
+	Archived bool
+}
+
+// Compact removes the history that precedes the clear retain places before
+// the most recent one, so retain=0 keeps only what playback needs. Unless
+// archive is empty, the removed records are appended to segments in the
+// archive directory instead of being discarded.
+//
+// Only compaction changes a sealed segment, so the segments compacted away
+// are counted and archived, and the boundary segment rewritten to a copy,
+// while writes carry on. Writes wait only while the copy replaces the
+// segment and the index is rewritten, which waits for reads in progress.
+func (s *Collector) Compact(retain int, archive string) (*Compaction, error) {
+	if retain < 0 {
+		return nil, fmt.Errorf("Invalid clear retention: %d", retain)
+	}
+
+	s.compacting.Lock()
+	defer s.compacting.Unlock()
+
+	result := &Compaction{Archived: archive != ""}
+
+	s.mutex.Lock()
+
+	if s.stopped {
+		s.mutex.Unlock()
+		return nil, fmt.Errorf("Cannot compact a stopped collector.")
+	}
+
+	if len(s.clears) <= retain {
+		s.mutex.Unlock()
+		return result, nil
+	}
+
+	// Clears are only appended, so those from here on are the ones kept
+	// however many are written meanwhile.
+	kept := len(s.clears) - 1 - retain
+	boundary := s.clears[kept]
+
+	// The boundary segment is rewritten, so it must not be the one being
+	// appended to.
+	if boundary.Segment == s.segments[len(s.segments)-1] {
+		if err := s.roll(); err != nil {
+			s.mutex.Unlock()
+			return nil, fmt.Errorf("Failed to roll over segment: %v", err)
+		}
+	}
+
+	discarded := []int64{}
+	for _, segment := range s.segments {
+		if segment < boundary.Segment {
+			discarded = append(discarded, segment)
+		}
+	}
+
+	s.mutex.Unlock()
+
+	if archive != "" {
+		if err := os.MkdirAll(archive, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, segment := range discarded {
+		records, bytes, err := s.archiveSegment(segment, archive)
+		if err != nil {
+			return nil, err
+		}
+
+		result.Segments = result.Segments + 1
+		result.Records = result.Records + records
+		result.Bytes = result.Bytes + bytes
+	}
+
+	offsets, trimmed, records, bytes, err := s.trimSegment(boundary, archive)
+	if err != nil {
+		return nil, err
+	}
+
+	result.Records = result.Records + records
+	result.Bytes = result.Bytes + bytes
+
+	s.files.Lock()
+	defer s.files.Unlock()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stopped {
+		if trimmed != "" {
+			os.Remove(trimmed)
+		}
+		return nil, fmt.Errorf("Cannot compact a stopped collector.")
+	}
+
+	if trimmed != "" {
+		if err := os.Rename(trimmed, path.Join(s.directory, segmentName(boundary.Segment))); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, segment := range discarded {
+		if err := os.Remove(path.Join(s.directory, segmentName(segment))); err != nil {
+			return nil, err
+		}
+	}
+
+	s.segments = s.segments[len(discarded):]
+
+	clears := []position{}
+	for _, p := range s.clears[kept:] {
+		if p.Segment == boundary.Segment && offsets != nil {
+			p.Offset = offsets[p.Offset]
+		}
+		clears = append(clears, p)
+	}
+
+	if err := s.replaceIndex(clears); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// archiveSegment counts the records of a segment about to be removed from the
+// log, and archives it if asked. The segment itself is left for Compact to
+// remove.
+func (s *Collector) archiveSegment(segment int64, archive string) (int, int64, error) {
+	pathname := path.Join(s.directory, segmentName(segment))
+
+	info, err := os.Stat(pathname)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	records := 0
+	err = scanSegment(s.directory, segment, 0, func(position, Record) error {
+		records = records + 1
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if archive == "" {
+		return records, info.Size(), nil
+	}
+
+	// Readers may still be reading the segment, so it is linked into the
+	// archive rather than moved, or copied across filesystems.
+	archived := path.Join(archive, segmentName(segment))
+	if _, err := os.Stat(archived); os.IsNotExist(err) {
+		if err := os.Link(pathname, archived); err == nil {
+			return records, info.Size(), nil
+		}
+	}
+
+	// Otherwise an earlier compaction archived the start of this segment
+	// already.
+	if err := appendRecords(archived, s.directory, segment, 0, -1); err != nil {
+		return 0, 0, err
+	}
+
+	return records, info.Size(), nil
+}
+
+// trimSegment writes a copy of the boundary segment that starts at the
+// boundary record, for Compact to move into place. It returns the copy and a
+// map from old to new offsets for the records it kept, or neither if the
+// segment already starts there.
+func (s *Collector) trimSegment(boundary position, archive string) (map[int64]int64, string, int, int64, error) {
+	pathname := path.Join(s.directory, segmentName(boundary.Segment))
+	temporary := pathname + ".tmp"
+
+	// Nothing to trim when the boundary is the first record of the segment.
+	first := int64(-1)
+	err := scanSegment(s.directory, boundary.Segment, 0, func(at position, record Record) error {
+		first = at.Offset
+		return errStopScan
+	})
+	if err != nil && err != errStopScan {
+		return nil, "", 0, 0, err
+	}
+
+	if first == boundary.Offset {
+		return nil, "", 0, 0, nil
+	}
+
+	if archive != "" {
+		archived := path.Join(archive, segmentName(boundary.Segment))
+		if err := appendRecords(archived, s.directory, boundary.Segment, 0, boundary.Offset); err != nil {
+			return nil, "", 0, 0, err
+		}
+	}
+
+	output, err := os.OpenFile(temporary, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, "", 0, 0, err
+	}
+
+	offsets := make(map[int64]int64)
+	offset := int64(segmentHeaderSize)
+	dropped := 0
+	droppedBytes := int64(0)
+
+	if _, err := output.Write(encodeHeader(FormatVersion)); err != nil {
+		output.Close()
+		os.Remove(temporary)
+		return nil, "", 0, 0, err
+	}
+
+	err = scanSegment(s.directory, boundary.Segment, 0, func(at position, record Record) error {
+		frame := encodeFrame(record)
+
+		if at.Offset < boundary.Offset {
+			dropped = dropped + 1
+			droppedBytes = droppedBytes + int64(len(frame))
+			return nil
+		}
+
+		if _, err := output.Write(frame); err != nil {
+			return err
+		}
+
+		offsets[at.Offset] = offset
+		offset = offset + int64(len(frame))
+		return nil
+	})
+
+	if err == nil {
+		err = output.Sync()
+	}
+
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(temporary)
+		return nil, "", 0, 0, fmt.Errorf("Failed to rewrite %s: %v", segmentName(boundary.Segment), err)
+	}
+
+	return offsets, temporary, dropped, droppedBytes, nil
+}
+
+// appendRecords copies the records of a segment that start before the given
+// offset (or all of them, if until is negative) onto the end of target.
+func appendRecords(target, directory string, segment, from, until int64) error {
+	output, err := os.OpenFile(target, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := output.Stat()
+	if err != nil {
+		output.Close()
+		return err
+	}
+
+	if info.Size() == 0 {
+		if _, err := output.Write(encodeHeader(FormatVersion)); err != nil {
+			output.Close()
+			return err
+		}
+	}
+
+	err = scanSegment(directory, segment, from, func(at position, record Record) error {
+		if until >= 0 && at.Offset >= until {
+			return errStopScan
+		}
+
+		_, err := output.Write(encodeFrame(record))
+		return err
+	})
+
+	if err == errStopScan {
+		err = nil
+	}
+
+	if err == nil {
+		err = output.Sync()
+	}
+
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
+
+// replaceIndex rewrites the sidecar index and reopens it for appending.
+func (s *Collector) replaceIndex(clears []position) error {
+	if err := s.index.Close(); err != nil {
+		return err
+	}
+
+	if err := writeIndex(s.directory, clears); err != nil {
+		return err
+	}
+
+	index, err := os.OpenFile(path.Join(s.directory, indexName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	s.index = index
+	s.clears = clears
+
+	return nil
+}
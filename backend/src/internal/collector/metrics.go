@@ -0,0 +1,15 @@
+package collector
+
+import "backend/internal/metrics"
+
+var (
+	// From 50µs to about 1.6s.
+	writeSeconds = metrics.Default.NewHistogram("doodleopia_collector_write_seconds",
+		"Time taken to append a record to a log.", metrics.ExponentialBuckets(0.00005, 2, 16)).With()
+
+	writtenBytes = metrics.Default.NewCounter("doodleopia_collector_written_bytes_total",
+		"Bytes appended to the logs.").With()
+
+	writeErrors = metrics.Default.NewCounter("doodleopia_collector_write_errors_total",
+		"Records that could not be written to a log.").With()
+)
@@ -0,0 +1,463 @@
+package collector
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// Segments are named collected.000000.bin, collected.000001.bin, ...
+	segmentPrefix = "collected."
+	segmentSuffix = ".bin"
+
+	// The unsegmented log written by earlier versions of the collector.
+	legacyName = "collected.bin"
+
+	// The sidecar index holding the position of every ActionClear.
+	indexName = "collected.idx"
+
+	// Size of one index entry: int64 segment followed by int64 offset.
+	indexEntrySize = 16
+
+	// The sidecar holding the highest client id in the log, a uint16.
+	clientIdName = "collected.cid"
+
+	// Segments are rolled over once they would grow past this size.
+	DefaultSegmentSize = 4 * 1024 * 1024
+
+	// Every new segment starts with segmentMagic, a uint16 format version
+	// and two reserved bytes.
+	segmentMagic      = "DLOG"
+	segmentHeaderSize = 8
+
+	// FormatVersion is the version written to new segments.
+	//   0: no header; each record is an int64 size and the payload.
+	//   1: each record is an int64 size, a CRC-32 of the payload, and the payload.
+	//   2: as 1, but the payload is preceded by an envelope of a uint64
+	//      sequence number and the int64 receive time in Unix nanoseconds,
+	//      both covered by the size and the CRC-32.
+	FormatVersion = 2
+
+	envelopeSize = 16
+
+	// Guards against allocating for a nonsensical size read from a damaged log.
+	maxRecordSize = 16 * 1024 * 1024
+)
+
+// position locates a record by segment number and byte offset in that segment.
+type position struct {
+	Segment int64
+	Offset  int64
+}
+
+func segmentName(segment int64) string {
+	return fmt.Sprintf("%s%06d%s", segmentPrefix, segment, segmentSuffix)
+}
+
+func parseSegmentName(name string) (int64, bool) {
+	if name == legacyName || !strings.HasPrefix(name, segmentPrefix) || !strings.HasSuffix(name, segmentSuffix) {
+		return 0, false
+	}
+
+	number := strings.TrimSuffix(strings.TrimPrefix(name, segmentPrefix), segmentSuffix)
+	segment, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || segment < 0 {
+		return 0, false
+	}
+
+	return segment, true
+}
+
+// listSegments returns the segment numbers found in directory, in order.
+func listSegments(directory string) ([]int64, error) {
+	files, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	segments := []int64{}
+	for _, file := range files {
+		if segment, ok := parseSegmentName(file.Name()); ok && !file.IsDir() {
+			segments = append(segments, segment)
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+
+	return segments, nil
+}
+
+// migrateLegacy turns an unsegmented collected.bin into the first segment.
+func migrateLegacy(directory string) error {
+	legacyPath := path.Join(directory, legacyName)
+	if _, err := os.Stat(legacyPath); os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	segments, err := listSegments(directory)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) > 0 {
+		return fmt.Errorf("Found both %s and segment files in %s.", legacyName, directory)
+	}
+
+	return os.Rename(legacyPath, path.Join(directory, segmentName(0)))
+}
+
+// readHeader reads the segment header, returning the format version and the
+// size of the header. Segments without a header are version 0.
+func readHeader(file io.ReadSeeker) (uint16, int64, error) {
+	header := make([]byte, segmentHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return 0, 0, err
+	}
+
+	if n == segmentHeaderSize && string(header[0:4]) == segmentMagic {
+		return binary.LittleEndian.Uint16(header[4:6]), segmentHeaderSize, nil
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+
+	return 0, 0, nil
+}
+
+func encodeHeader(version uint16) []byte {
+	header := make([]byte, segmentHeaderSize)
+	copy(header[0:4], segmentMagic)
+	binary.LittleEndian.PutUint16(header[4:6], version)
+	return header
+}
+
+// CorruptError describes a record that could not be read back intact.
+type CorruptError struct {
+	Segment int64
+	Offset  int64
+	Reason  string
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("Corrupt record in %s at offset %d: %s", segmentName(e.Segment), e.Offset, e.Reason)
+}
+
+type frameError string
+
+func (e frameError) Error() string {
+	return string(e)
+}
+
+// readFrame reads one record in the given format version, returning it and
+// the number of bytes consumed. A clean end of the segment is reported as
+// io.EOF and anything else that went wrong as a frameError. Records from
+// before version 2 have no sequence number or receive time.
+func readFrame(reader io.Reader, version uint16) (Record, int64, error) {
+	var size int64
+	if err := binary.Read(reader, binary.LittleEndian, &size); err == io.EOF {
+		return Record{}, 0, io.EOF
+	} else if err == io.ErrUnexpectedEOF {
+		return Record{}, 0, frameError("truncated record size")
+	} else if err != nil {
+		return Record{}, 0, err
+	}
+
+	if size < 0 || size > maxRecordSize {
+		return Record{}, 0, frameError(fmt.Sprintf("invalid record size %d", size))
+	}
+
+	consumed := 8 + size
+
+	var checksum uint32
+	if version >= 1 {
+		if err := binary.Read(reader, binary.LittleEndian, &checksum); err == io.EOF || err == io.ErrUnexpectedEOF {
+			return Record{}, 0, frameError("truncated record checksum")
+		} else if err != nil {
+			return Record{}, 0, err
+		}
+		consumed = consumed + 4
+	}
+
+	content := make([]byte, size)
+	if _, err := io.ReadFull(reader, content); err == io.EOF || err == io.ErrUnexpectedEOF {
+		return Record{}, 0, frameError(fmt.Sprintf("truncated record payload, expected %d bytes", size))
+	} else if err != nil {
+		return Record{}, 0, err
+	}
+
+	if version >= 1 && crc32.ChecksumIEEE(content) != checksum {
+		return Record{}, 0, frameError("checksum mismatch")
+	}
+
+	if version < 2 {
+		return Record{Data: content}, consumed, nil
+	}
+
+	if size < envelopeSize {
+		return Record{}, 0, frameError(fmt.Sprintf("record of %d bytes is too short for its envelope", size))
+	}
+
+	record := Record{
+		Sequence: binary.LittleEndian.Uint64(content[0:8]),
+		Received: time.Unix(0, int64(binary.LittleEndian.Uint64(content[8:16]))),
+		Data:     content[envelopeSize:],
+	}
+
+	return record, consumed, nil
+}
+
+// encodeFrame frames a record in the current FormatVersion.
+func encodeFrame(record Record) []byte {
+	size := envelopeSize + len(record.Data)
+
+	received := int64(0)
+	if !record.Received.IsZero() {
+		received = record.Received.UnixNano()
+	}
+
+	frame := make([]byte, 12+size)
+	binary.LittleEndian.PutUint64(frame[0:8], uint64(size))
+	binary.LittleEndian.PutUint64(frame[12:20], record.Sequence)
+	binary.LittleEndian.PutUint64(frame[20:28], uint64(received))
+	copy(frame[28:], record.Data)
+	binary.LittleEndian.PutUint32(frame[8:12], crc32.ChecksumIEEE(frame[12:]))
+	return frame
+}
+
+// scanSegment calls fn with the position of every record in the segment,
+// starting at offset. Damaged records stop the scan with a *CorruptError.
+func scanSegment(directory string, segment, offset int64, fn func(position, Record) error) error {
+	return scanSegmentUntil(directory, segment, offset, -1, fn)
+}
+
+// scanSegmentUntil is scanSegment stopping at the until offset, or at the end
+// of the segment if until is negative, so that a record being appended past
+// it is not taken for a torn one.
+func scanSegmentUntil(directory string, segment, offset, until int64, fn func(position, Record) error) error {
+	file, err := os.Open(path.Join(directory, segmentName(segment)))
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	version, headerSize, err := readHeader(file)
+	if err != nil {
+		return err
+	}
+
+	if version > FormatVersion {
+		return fmt.Errorf("%s has unsupported format version %d.", segmentName(segment), version)
+	}
+
+	if offset < headerSize {
+		offset = headerSize
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	var reader io.Reader = file
+	if until >= 0 {
+		reader = io.LimitReader(file, until-offset)
+	}
+	buffered := bufio.NewReader(reader)
+
+	for {
+		record, n, err := readFrame(buffered, version)
+		if err == io.EOF {
+			return nil
+		} else if reason, ok := err.(frameError); ok {
+			return &CorruptError{Segment: segment, Offset: offset, Reason: string(reason)}
+		} else if err != nil {
+			return fmt.Errorf("Failed to read %s at offset %d: %v", segmentName(segment), offset, err)
+		}
+
+		if err := fn(position{Segment: segment, Offset: offset}, record); err != nil {
+			return err
+		}
+
+		offset = offset + n
+	}
+}
+
+// Recovery reports what was cut from the end of the log when it was opened.
+type Recovery struct {
+	Segment        int64
+	Offset         int64
+	Reason         string
+	DroppedRecords int
+	DroppedBytes   int64
+}
+
+// recoverSegment truncates the segment at its first damaged record. Only the
+// tail of the last segment can be torn by a crash, so that is the only one
+// repaired; damage elsewhere is left for Read to report.
+func recoverSegment(directory string, segment int64) (*Recovery, error) {
+	err := scanSegment(directory, segment, 0, func(position, Record) error { return nil })
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	corrupt, ok := err.(*CorruptError)
+	if !ok {
+		return nil, err
+	}
+
+	pathname := path.Join(directory, segmentName(segment))
+	file, err := os.Open(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	version, _, err := readHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := file.Seek(corrupt.Offset, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	recovery := &Recovery{
+		Segment:        segment,
+		Offset:         corrupt.Offset,
+		Reason:         corrupt.Reason,
+		DroppedRecords: countFrames(bufio.NewReader(file), version, info.Size()-corrupt.Offset),
+		DroppedBytes:   info.Size() - corrupt.Offset,
+	}
+
+	if err := os.Truncate(pathname, corrupt.Offset); err != nil {
+		return nil, err
+	}
+
+	return recovery, nil
+}
+
+// countFrames counts the records that appear to be in the remaining bytes by
+// following their size prefixes, without trusting their contents. A trailing
+// partial record counts as one.
+func countFrames(reader io.Reader, version uint16, remaining int64) int {
+	overhead := int64(8)
+	if version >= 1 {
+		overhead = 12
+	}
+
+	count := 0
+	for remaining > 0 {
+		count = count + 1
+
+		var size int64
+		if err := binary.Read(reader, binary.LittleEndian, &size); err != nil {
+			break
+		}
+
+		if size < 0 || size > maxRecordSize || overhead+size >= remaining {
+			break
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, reader, overhead-8+size); err != nil {
+			break
+		}
+
+		remaining = remaining - overhead - size
+	}
+
+	return count
+}
+
+func readIndex(directory string) ([]position, error) {
+	content, err := ioutil.ReadFile(path.Join(directory, indexName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(content)%indexEntrySize != 0 {
+		return nil, fmt.Errorf("Index has a partial entry (%d bytes).", len(content))
+	}
+
+	clears := make([]position, len(content)/indexEntrySize)
+	for idx := range clears {
+		entry := content[idx*indexEntrySize:]
+		clears[idx] = position{
+			Segment: int64(binary.LittleEndian.Uint64(entry[0:8])),
+			Offset:  int64(binary.LittleEndian.Uint64(entry[8:16])),
+		}
+	}
+
+	return clears, nil
+}
+
+func encodeIndexEntry(p position) []byte {
+	entry := make([]byte, indexEntrySize)
+	binary.LittleEndian.PutUint64(entry[0:8], uint64(p.Segment))
+	binary.LittleEndian.PutUint64(entry[8:16], uint64(p.Offset))
+	return entry
+}
+
+// readClientId returns the highest client id recorded beside the log, and
+// false if there is no record of it.
+func readClientId(directory string) (uint16, bool, error) {
+	content, err := ioutil.ReadFile(path.Join(directory, clientIdName))
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+
+	if len(content) != 2 {
+		return 0, false, nil
+	}
+
+	return binary.LittleEndian.Uint16(content), true, nil
+}
+
+func writeClientId(directory string, id uint16) error {
+	content := make([]byte, 2)
+	binary.LittleEndian.PutUint16(content, id)
+
+	temporary := path.Join(directory, clientIdName+".tmp")
+	if err := ioutil.WriteFile(temporary, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path.Join(directory, clientIdName))
+}
+
+func writeIndex(directory string, clears []position) error {
+	content := make([]byte, 0, len(clears)*indexEntrySize)
+	for _, p := range clears {
+		content = append(content, encodeIndexEntry(p)...)
+	}
+
+	temporary := path.Join(directory, indexName+".tmp")
+	if err := ioutil.WriteFile(temporary, content, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(temporary, path.Join(directory, indexName))
+}
@@ -0,0 +1,305 @@
+// Package config gathers the settings of the system binary from a YAML file,
+// the environment and flags, in increasing order of precedence.
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/certs"
+	"backend/internal/logging"
+	"backend/internal/messaging"
+	"backend/internal/origins"
+	"backend/internal/vector"
+)
+
+type Config struct {
+	// Base data directory.
+	Directory string `yaml:"directory"`
+
+	// Ports of the services when each has its own listener.
+	VectorPort  int `yaml:"vector_port"`
+	MessagePort int `yaml:"message_port"`
+
+	// Address of the gateway serving everything on one listener, or "" for
+	// a port per service, and the built frontend it serves, behind the same
+	// authentication as the services.
+	Gateway string `yaml:"gateway"`
+	Static  string `yaml:"static"`
+
+	// Time allowed for requests in flight to finish when stopping.
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+
+	// Other origins whose pages may use the services.
+	Origins []string `yaml:"origins"`
+
+	Log      Log      `yaml:"log"`
+	TLS      TLS      `yaml:"tls"`
+	Auth     Auth     `yaml:"auth"`
+	Messages Messages `yaml:"messages"`
+}
+
+type Log struct {
+	// Least severe lines written: debug, info, warn or error.
+	Level string `yaml:"level"`
+
+	// logfmt or json.
+	Format string `yaml:"format"`
+}
+
+type TLS struct {
+	// Certificate and key served, reloaded when they change or on SIGHUP.
+	// Without them everything is served over plain HTTP.
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+
+	// Address of a plain HTTP listener sending browsers on to the gateway
+	// over HTTPS, or "".
+	Redirect string `yaml:"redirect"`
+}
+
+type Auth struct {
+	// File holding the key that signs access tokens.
+	KeyFile string `yaml:"key_file"`
+
+	// Shared password admitting anyone who presents it.
+	Password string `yaml:"password"`
+
+	// Role of clients whose token names none.
+	DefaultRole string `yaml:"default_role"`
+}
+
+type Messages struct {
+	// Previous clears kept when compacting, and whether the rest is
+	// archived, under message-archive, rather than discarded.
+	Retain  int  `yaml:"retain"`
+	Archive bool `yaml:"archive"`
+
+	// Largest coordinate accepted from clients.
+	Extent float64 `yaml:"extent"`
+
+	WriteWait       time.Duration `yaml:"write_wait"`
+	PongWait        time.Duration `yaml:"pong_wait"`
+	MaxMessageSize  int64         `yaml:"max_message_size"`
+	ReadBufferSize  int           `yaml:"read_buffer_size"`
+	WriteBufferSize int           `yaml:"write_buffer_size"`
+	SendBuffer      int           `yaml:"send_buffer"`
+
+	// Rate limits of each client, see messaging.Limits.
+	Rate         float64 `yaml:"rate"`
+	Burst        int     `yaml:"burst"`
+	RatePolicy   string  `yaml:"rate_policy"`
+	CursorRate   float64 `yaml:"cursor_rate"`
+	CursorBurst  int     `yaml:"cursor_burst"`
+	CursorPolicy string  `yaml:"cursor_policy"`
+}
+
+// DefaultShutdownTimeout is the shutdown_timeout of a configuration that does
+// not set one, for the services and the gateway alike.
+const DefaultShutdownTimeout = 5 * time.Second
+
+// Default is the configuration of a system started without any.
+func Default() *Config {
+	return &Config{
+		Directory:       ".",
+		VectorPort:      9200,
+		MessagePort:     9300,
+		ShutdownTimeout: DefaultShutdownTimeout,
+		Origins:         []string{},
+		Log: Log{
+			Level:  logging.LevelInfo.String(),
+			Format: logging.FormatLogfmt.String(),
+		},
+		Auth: Auth{
+			DefaultRole: messaging.RoleOwner.String(),
+		},
+		Messages: Messages{
+			Extent:          messaging.DefaultExtent,
+			WriteWait:       messaging.DefaultConnection.WriteWait,
+			PongWait:        messaging.DefaultConnection.PongWait,
+			MaxMessageSize:  messaging.DefaultConnection.MaxMessageSize,
+			ReadBufferSize:  messaging.DefaultConnection.ReadBufferSize,
+			WriteBufferSize: messaging.DefaultConnection.WriteBufferSize,
+			SendBuffer:      messaging.DefaultConnection.SendBuffer,
+			Rate:            messaging.DefaultLimits.Actions.Rate,
+			Burst:           messaging.DefaultLimits.Actions.Burst,
+			RatePolicy:      messaging.DefaultLimits.Actions.Policy.String(),
+			CursorRate:      messaging.DefaultLimits.Cursor.Rate,
+			CursorBurst:     messaging.DefaultLimits.Cursor.Burst,
+			CursorPolicy:    messaging.DefaultLimits.Cursor.Policy.String(),
+		},
+	}
+}
+
+// Validate reports a setting that the services could not run with.
+func (c *Config) Validate() error {
+	for name, port := range map[string]int{"vector_port": c.VectorPort, "message_port": c.MessagePort} {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("%s %d is not a port", name, port)
+		}
+	}
+
+	if c.Gateway == "" && c.VectorPort == c.MessagePort {
+		return fmt.Errorf("vector_port and message_port are both %d", c.VectorPort)
+	}
+
+	if c.Static != "" && c.Gateway == "" {
+		return fmt.Errorf("static is only served by the gateway")
+	}
+
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		return fmt.Errorf("tls needs both cert_file and key_file")
+	}
+
+	if c.TLS.Redirect != "" && (c.TLS.CertFile == "" || c.Gateway == "") {
+		return fmt.Errorf("tls.redirect needs the gateway to serve HTTPS")
+	}
+
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		return err
+	}
+
+	if _, err := logging.ParseFormat(c.Log.Format); err != nil {
+		return err
+	}
+
+	if c.Auth.KeyFile != "" && c.Auth.Password != "" {
+		return fmt.Errorf("use either auth.key_file or auth.password, not both")
+	}
+
+	if _, err := messaging.ParseRole(c.Auth.DefaultRole); err != nil {
+		return err
+	}
+
+	m := c.Messages
+
+	durations := map[string]time.Duration{
+		"shutdown_timeout":    c.ShutdownTimeout,
+		"messages.write_wait": m.WriteWait,
+		"messages.pong_wait":  m.PongWait,
+	}
+	for name, duration := range durations {
+		if duration <= 0 {
+			return fmt.Errorf("%s must be positive, not %v", name, duration)
+		}
+	}
+
+	sizes := map[string]int64{
+		"messages.max_message_size":  m.MaxMessageSize,
+		"messages.read_buffer_size":  int64(m.ReadBufferSize),
+		"messages.write_buffer_size": int64(m.WriteBufferSize),
+		"messages.send_buffer":       int64(m.SendBuffer),
+	}
+	for name, size := range sizes {
+		if size <= 0 {
+			return fmt.Errorf("%s must be positive, not %d", name, size)
+		}
+	}
+
+	if m.Retain < 0 {
+		return fmt.Errorf("messages.retain cannot be negative")
+	}
+
+	if m.Extent <= 0 {
+		return fmt.Errorf("messages.extent must be positive")
+	}
+
+	if m.Rate < 0 || m.CursorRate < 0 || m.Burst < 0 || m.CursorBurst < 0 {
+		return fmt.Errorf("rate limits cannot be negative")
+	}
+
+	for _, policy := range []string{m.RatePolicy, m.CursorPolicy} {
+		if _, err := messaging.ParsePolicy(policy); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Logger writes the configured lines to stderr. The configuration must be
+// valid.
+func (c *Config) Logger() *logging.Logger {
+	level, _ := logging.ParseLevel(c.Log.Level)
+	format, _ := logging.ParseFormat(c.Log.Format)
+
+	return logging.New(os.Stderr, format, level)
+}
+
+// Authenticator returns what the configuration authenticates with, or nil if
+// the services are open to anyone.
+func (c *Config) Authenticator() (auth.Authenticator, error) {
+	switch {
+	case c.Auth.KeyFile != "":
+		key, err := auth.LoadKey(c.Auth.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		return auth.NewHMAC(key), nil
+	case c.Auth.Password != "":
+		return &auth.Password{Password: c.Auth.Password}, nil
+	}
+	return nil, nil
+}
+
+// Reloader loads the TLS certificate, or returns nil if there is none.
+func (c *Config) Reloader(log *logging.Logger) (*certs.Reloader, error) {
+	if c.TLS.CertFile == "" {
+		return nil, nil
+	}
+	return certs.NewReloader(c.TLS.CertFile, c.TLS.KeyFile, log)
+}
+
+// VectorStore configures the vector service. The configuration must be valid.
+func (c *Config) VectorStore(stop chan struct{}, authenticator auth.Authenticator, tlsConfig *tls.Config, log *logging.Logger) vector.Store {
+	return vector.Store{
+		Directory:       path.Join(c.Directory, "vector"),
+		Stop:            stop,
+		Auth:            authenticator,
+		Origins:         origins.List(c.Origins),
+		ShutdownTimeout: c.ShutdownTimeout,
+		TLS:             tlsConfig,
+		Log:             log,
+	}
+}
+
+// MessageStore configures the message service. The configuration must be
+// valid.
+func (c *Config) MessageStore(stop chan struct{}, compact chan struct{}, authenticator auth.Authenticator, tlsConfig *tls.Config, log *logging.Logger) messaging.Store {
+	m := c.Messages
+
+	role, _ := messaging.ParseRole(c.Auth.DefaultRole)
+	ratePolicy, _ := messaging.ParsePolicy(m.RatePolicy)
+	cursorPolicy, _ := messaging.ParsePolicy(m.CursorPolicy)
+
+	return messaging.Store{
+		Directory: path.Join(c.Directory, "message"),
+		Stop:      stop,
+		Compact:   compact,
+		Retain:    m.Retain,
+		Archive:   m.Archive,
+		Extent:    m.Extent,
+		Connection: messaging.Connection{
+			WriteWait:       m.WriteWait,
+			PongWait:        m.PongWait,
+			MaxMessageSize:  m.MaxMessageSize,
+			ReadBufferSize:  m.ReadBufferSize,
+			WriteBufferSize: m.WriteBufferSize,
+			SendBuffer:      m.SendBuffer,
+		},
+		ShutdownTimeout: c.ShutdownTimeout,
+		TLS:             tlsConfig,
+		Limits: messaging.Limits{
+			Actions: messaging.RateLimit{Rate: m.Rate, Burst: m.Burst, Policy: ratePolicy},
+			Cursor:  messaging.RateLimit{Rate: m.CursorRate, Burst: m.CursorBurst, Policy: cursorPolicy},
+		},
+		Auth:        authenticator,
+		Origins:     origins.List(c.Origins),
+		DefaultRole: role,
+		Log:         log,
+	}
+}
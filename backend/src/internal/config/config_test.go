@@ -0,0 +1,135 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/messaging"
+)
+
+func writeConfig(t *testing.T, content string) string {
+	file, err := ioutil.TempFile("", "config-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(content)
+	file.Close()
+	return file.Name()
+}
+
+func env(vars map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+}
+
+func TestDefaults(t *testing.T) {
+	c, err := Load("test", []string{}, env(nil))
+	if err != nil {
+		t.Fatalf("Want the defaults valid; Got %v", err)
+	}
+
+	store := c.MessageStore(nil, nil, nil, nil, nil)
+	if store.Connection != messaging.DefaultConnection || store.Limits != messaging.DefaultLimits {
+		t.Errorf("Want the message store's defaults; Got %+v", store)
+	}
+	if store.DefaultRole != messaging.RoleOwner || c.VectorPort != 9200 || c.MessagePort != 9300 {
+		t.Errorf("Want the defaults the services had before configuration; Got %+v", c)
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	filename := writeConfig(t, `
+directory: /srv/doodles
+vector_port: 8200
+origins: [https://portal.example.com]
+messages:
+  pong_wait: 30s
+  send_buffer: 4096
+  rate: 50
+`)
+	defer os.Remove(filename)
+
+	vars := map[string]string{
+		"DOODLEOPIA_VECTOR_PORT": "8201",
+		"DOODLEOPIA_SEND_BUFFER": "2048",
+		"DOODLEOPIA_DIRECTORY":   "/var/doodles",
+	}
+	args := []string{"-config", filename, "-send-buffer", "1000"}
+
+	c, err := Load("test", args, env(vars))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Messages.PongWait != 30*time.Second || c.Messages.Rate != 50 {
+		t.Errorf("Want settings from the file; Got %+v", c.Messages)
+	}
+	if c.VectorPort != 8201 || c.Directory != "/var/doodles" {
+		t.Errorf("Want the environment to override the file; Got %+v", c)
+	}
+	if c.Messages.SendBuffer != 1000 {
+		t.Errorf("Want flags to override everything; Got %d", c.Messages.SendBuffer)
+	}
+	if len(c.Origins) != 1 || c.Origins[0] != "https://portal.example.com" {
+		t.Errorf("Want origins from the file; Got %q", c.Origins)
+	}
+
+	// The file can be named in the environment too.
+	c, err = Load("test", []string{}, env(map[string]string{"DOODLEOPIA_CONFIG": filename}))
+	if err != nil || c.VectorPort != 8200 {
+		t.Errorf("Want the file named by DOODLEOPIA_CONFIG read; Got %v", err)
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	unknown := writeConfig(t, "messages:\n  pong_wiat: 30s\n")
+	defer os.Remove(unknown)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"misspelt setting", []string{"-config", unknown}, "pong_wiat"},
+		{"missing file", []string{"-config", "/nonexistent/config.yaml"}, "no such file"},
+		{"same ports", []string{"-vector-port", "9300"}, "both 9300"},
+		{"static without gateway", []string{"-static", "www"}, "gateway"},
+		{"zero wait", []string{"-pong-wait", "0s"}, "messages.pong_wait"},
+		{"unknown policy", []string{"-rate-policy", "ignore"}, "ignore"},
+		{"unknown role", []string{"-default-role", "admin"}, "admin"},
+		{"unknown log level", []string{"-log-level", "verbose"}, "verbose"},
+		{"unknown log format", []string{"-log-format", "xml"}, "xml"},
+		{"key and password", []string{"-auth-key", "key", "-auth-password", "secret"}, "not both"},
+		{"cert without key", []string{"-tls-cert", "cert.pem"}, "key_file"},
+		{"redirect without gateway", []string{"-tls-cert", "c", "-tls-key", "k", "-tls-redirect", ":80"}, "gateway"},
+	}
+
+	for _, test := range tests {
+		_, err := Load("test", test.args, env(nil))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: Want an error mentioning '%s'; Got %v", test.name, test.want, err)
+		}
+	}
+
+	_, err := Load("test", nil, env(map[string]string{"DOODLEOPIA_RATE": "fast"}))
+	if err == nil || !strings.Contains(err.Error(), "DOODLEOPIA_RATE") {
+		t.Errorf("Want a bad environment variable named; Got %v", err)
+	}
+}
+
+func TestExample(t *testing.T) {
+	c, err := Load("test", []string{"-config", "../../../config.example.yaml"}, env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(c, Default()) {
+		t.Errorf("Want the example to list the defaults; Got %+v", c)
+	}
+}
@@ -0,0 +1,145 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"backend/internal/origins"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Every flag can also be set in the environment, as EnvPrefix followed by its
+// name in upper case with dashes as underscores: -pong-wait is
+// DOODLEOPIA_PONG_WAIT. The config file itself is -config or DOODLEOPIA_CONFIG.
+const EnvPrefix = "DOODLEOPIA_"
+
+// Flags whose environment variable is not simply named after them.
+var envNames = map[string]string{
+	"d": EnvPrefix + "DIRECTORY",
+}
+
+func envName(flagName string) string {
+	if name, ok := envNames[flagName]; ok {
+		return name
+	}
+	return EnvPrefix + strings.ToUpper(strings.Replace(flagName, "-", "_", -1))
+}
+
+// A comma separated list of origins, as a flag.
+type list struct {
+	values *[]string
+}
+
+func (l list) String() string {
+	if l.values == nil {
+		return ""
+	}
+	return strings.Join(*l.values, ",")
+}
+
+func (l list) Set(value string) error {
+	*l.values = []string(origins.Parse(value))
+	return nil
+}
+
+// bind registers the flags of every setting in c.
+func bind(fs *flag.FlagSet, c *Config) {
+	m := &c.Messages
+
+	fs.StringVar(&c.Directory, "d", c.Directory, "base data directory")
+	fs.IntVar(&c.VectorPort, "vector-port", c.VectorPort, "port of the vector service")
+	fs.IntVar(&c.MessagePort, "message-port", c.MessagePort, "port of the message service")
+	fs.StringVar(&c.Gateway, "gateway", c.Gateway, "serve everything on this address, such as :8080, instead of a port per service")
+	fs.StringVar(&c.Static, "static", c.Static, "directory of the built frontend, served by the gateway")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "time allowed for requests in flight to finish when stopping")
+	fs.Var(list{&c.Origins}, "origins", "comma separated origins, besides the services' own, whose pages may use the services")
+
+	fs.StringVar(&c.Log.Level, "log-level", c.Log.Level, "least severe log lines written: debug, info, warn or error")
+	fs.StringVar(&c.Log.Format, "log-format", c.Log.Format, "log lines as logfmt or json")
+
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "certificate served over HTTPS, reloaded when it changes")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "key of the certificate served over HTTPS")
+	fs.StringVar(&c.TLS.Redirect, "tls-redirect", c.TLS.Redirect, "address of a plain HTTP listener redirecting to the gateway over HTTPS")
+
+	fs.StringVar(&c.Auth.KeyFile, "auth-key", c.Auth.KeyFile, "file holding the key that signs access tokens")
+	fs.StringVar(&c.Auth.Password, "auth-password", c.Auth.Password, "shared password admitting anyone who presents it")
+	fs.StringVar(&c.Auth.DefaultRole, "default-role", c.Auth.DefaultRole, "role of clients whose token names none: owner, editor or viewer")
+
+	fs.IntVar(&m.Retain, "retain", m.Retain, "previous clears kept when compacting message logs")
+	fs.BoolVar(&m.Archive, "archive", m.Archive, "archive compacted message history instead of discarding it")
+	fs.Float64Var(&m.Extent, "extent", m.Extent, "largest coordinate accepted from clients")
+	fs.DurationVar(&m.WriteWait, "write-wait", m.WriteWait, "time allowed to write a message to a client")
+	fs.DurationVar(&m.PongWait, "pong-wait", m.PongWait, "time allowed for a client to answer a ping")
+	fs.Int64Var(&m.MaxMessageSize, "max-message-size", m.MaxMessageSize, "largest message accepted from clients, in bytes")
+	fs.IntVar(&m.ReadBufferSize, "read-buffer-size", m.ReadBufferSize, "websocket read buffer, in bytes")
+	fs.IntVar(&m.WriteBufferSize, "write-buffer-size", m.WriteBufferSize, "websocket write buffer, in bytes")
+	fs.IntVar(&m.SendBuffer, "send-buffer", m.SendBuffer, "messages queued for a client before it is disconnected as too slow")
+	fs.Float64Var(&m.Rate, "rate", m.Rate, "messages per second allowed from each client, per action")
+	fs.IntVar(&m.Burst, "burst", m.Burst, "burst of messages allowed from each client, per action")
+	fs.StringVar(&m.RatePolicy, "rate-policy", m.RatePolicy, "drop, coalesce or disconnect clients over the rate limit")
+	fs.Float64Var(&m.CursorRate, "cursor-rate", m.CursorRate, "cursor messages per second allowed from each client")
+	fs.IntVar(&m.CursorBurst, "cursor-burst", m.CursorBurst, "burst of cursor messages allowed from each client")
+	fs.StringVar(&m.CursorPolicy, "cursor-policy", m.CursorPolicy, "drop, coalesce or disconnect clients over the cursor rate limit")
+}
+
+// Load builds the configuration from the defaults, then the config file,
+// then the environment, then the command line, and validates it. lookupEnv is
+// os.LookupEnv outside of tests.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	// A first pass over the command line, for the config file and the flags
+	// that override it.
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	bind(fs, Default())
+	filename := fs.String("config", "", "YAML file of settings, overridden by the environment and flags")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *filename == "" {
+		*filename, _ = lookupEnv(EnvPrefix + "CONFIG")
+	}
+
+	c := Default()
+	if *filename != "" {
+		data, err := ioutil.ReadFile(*filename)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, c); err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %v", *filename, err)
+		}
+	}
+
+	settings := flag.NewFlagSet(name, flag.ContinueOnError)
+	bind(settings, c)
+
+	var err error
+	settings.VisitAll(func(f *flag.Flag) {
+		if value, ok := lookupEnv(envName(f.Name)); ok && err == nil {
+			if setErr := settings.Set(f.Name, value); setErr != nil {
+				err = fmt.Errorf("%s: %v", envName(f.Name), setErr)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name != "config" && err == nil {
+			err = settings.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("Invalid configuration: %v", err)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,129 @@
+// Package gateway serves the whole application on a single listener, laid out
+// as the frontend expects: the vector service under /api/vector/, the message
+// service under /api/message/ and, optionally, the built frontend itself.
+package gateway
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/certs"
+	"backend/internal/logging"
+
+	"github.com/go-chi/chi"
+)
+
+type Gateway struct {
+	Vector  http.Handler
+	Message http.Handler
+
+	// Directory of the built frontend, or "" to serve only the services.
+	Static string
+
+	// Authenticates requests for the frontend, as the services do theirs,
+	// or nil to serve it to anyone.
+	Auth auth.Authenticator
+
+	// How long Serve waits, once stop is closed, for requests to finish.
+	ShutdownTimeout time.Duration
+
+	// Serves HTTPS if set, see certs.
+	TLS *tls.Config
+
+	// Address of a plain HTTP listener sending browsers on to HTTPS, or "".
+	Redirect string
+
+	// Where the gateway logs, or nil for logging.Default.
+	Log *logging.Logger
+}
+
+func (g *Gateway) Handler() http.Handler {
+	r := chi.NewRouter()
+
+	// The vector service sees paths as it would on a port of its own.
+	r.Mount("/api/vector", http.StripPrefix("/api/vector", g.Vector))
+	r.Mount("/api/message", g.Message)
+
+	// One probe for the orchestrator, passing only if both services pass.
+	r.Get("/healthz", g.probe("/healthz"))
+	r.Get("/readyz", g.probe("/readyz"))
+
+	if g.Static != "" {
+		r.With(auth.Middleware(g.Auth)).Handle("/*", http.FileServer(http.Dir(g.Static)))
+	}
+
+	return r
+}
+
+// probe asks both services for the probe at path, answering with the worse
+// status and both their answers.
+func (g *Gateway) probe(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		services := []struct {
+			name    string
+			handler http.Handler
+		}{{"vector", g.Vector}, {"message", g.Message}}
+
+		status := http.StatusOK
+		lines := []string{}
+
+		for _, service := range services {
+			recorder := httptest.NewRecorder()
+			service.handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+			if recorder.Code != http.StatusOK {
+				status = http.StatusServiceUnavailable
+			}
+			for _, line := range strings.Split(strings.TrimSpace(recorder.Body.String()), "\n") {
+				lines = append(lines, service.name+" "+line)
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(status)
+		w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+	}
+}
+
+// Serve listens on addr, and on the redirect address if there is one, until
+// stop is closed.
+func (g *Gateway) Serve(addr string, stop chan struct{}) {
+	logger := g.Log.With("component", "gateway")
+	errorLog := log.New(logger.Writer(logging.LevelWarn), "", 0)
+
+	servers := []*http.Server{{Addr: addr, Handler: g.Handler(), TLSConfig: g.TLS, ErrorLog: errorLog}}
+
+	if g.Redirect != "" {
+		_, port, _ := net.SplitHostPort(addr)
+		servers = append(servers, &http.Server{Addr: g.Redirect, Handler: certs.Redirect(port), ErrorLog: errorLog})
+	}
+
+	for _, server := range servers {
+		go func(server *http.Server) {
+			logger.Info("Serving", "addr", server.Addr)
+			if err := certs.ListenAndServe(server); err != http.ErrServerClosed {
+				logger.Error("Failed to serve", "addr", server.Addr, "err", err)
+			}
+		}(server)
+	}
+
+	<-stop
+
+	ctx, cancel := context.WithTimeout(context.Background(), g.ShutdownTimeout)
+
+	defer cancel()
+
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("Failed to shut down", "addr", server.Addr, "err", err)
+		}
+	}
+}
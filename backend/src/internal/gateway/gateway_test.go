@@ -0,0 +1,143 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"backend/internal/auth"
+
+	"github.com/go-chi/chi"
+)
+
+// echo answers with the name of the service and the path it was given.
+func echo(name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(name + " " + r.URL.Path))
+	}
+}
+
+func TestGateway(t *testing.T) {
+	static, err := ioutil.TempDir("", "gateway-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(static)
+
+	ioutil.WriteFile(path.Join(static, "index.html"), []byte("frontend"), 0644)
+
+	message := chi.NewRouter()
+	message.Get("/", echo("board"))
+	message.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("room " + chi.URLParam(r, "id")))
+	})
+
+	g := Gateway{Vector: echo("vector"), Message: message, Static: static}
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/api/vector/drawing.json", "vector /drawing.json"},
+		{"/api/vector/", "vector /"},
+		{"/api/message", "board /api/message"},
+		{"/api/message/rooms/lobby", "room lobby"},
+		{"/", "frontend"},
+	}
+
+	for _, test := range tests {
+		response, err := http.Get(server.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+
+		if string(body) != test.want {
+			t.Errorf("%s: Want '%s'; Got '%s'", test.path, test.want, body)
+		}
+	}
+}
+
+func TestStaticBehindAuth(t *testing.T) {
+	static, err := ioutil.TempDir("", "gateway-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(static)
+
+	ioutil.WriteFile(path.Join(static, "index.html"), []byte("frontend"), 0644)
+
+	g := Gateway{Vector: echo("vector"), Message: echo("message"), Static: static, Auth: &auth.Password{Password: "secret"}}
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusUnauthorized || response.Header.Get("WWW-Authenticate") == "" {
+		t.Errorf("Want the frontend refused without the password; Got %d", response.StatusCode)
+	}
+
+	request, _ := http.NewRequest(http.MethodGet, server.URL+"/", nil)
+	request.SetBasicAuth("", "secret")
+
+	response, err = http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+
+	if string(body) != "frontend" {
+		t.Errorf("Want the frontend with the password; Got %d '%s'", response.StatusCode, body)
+	}
+}
+
+func TestProbes(t *testing.T) {
+	healthy := func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok\n"))
+	}
+	unready := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/readyz" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("directory: read-only file system\n"))
+			return
+		}
+		w.Write([]byte("ok\n"))
+	}
+
+	g := Gateway{Vector: http.HandlerFunc(healthy), Message: http.HandlerFunc(unready)}
+	server := httptest.NewServer(g.Handler())
+	defer server.Close()
+
+	tests := []struct {
+		path   string
+		status int
+		want   string
+	}{
+		{"/healthz", http.StatusOK, "vector ok\nmessage ok\n"},
+		{"/readyz", http.StatusServiceUnavailable, "vector ok\nmessage directory: read-only file system\n"},
+	}
+
+	for _, test := range tests {
+		response, err := http.Get(server.URL + test.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+
+		if response.StatusCode != test.status || string(body) != test.want {
+			t.Errorf("%s: Want %d '%s'; Got %d '%s'", test.path, test.status, test.want, response.StatusCode, body)
+		}
+	}
+}
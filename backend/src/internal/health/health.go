@@ -0,0 +1,104 @@
+// Package health answers an orchestrator's probes: /healthz for whether a
+// service is alive, failing when it is wedged and should be restarted, and
+// /readyz for whether it can serve, failing while a dependency such as its
+// data directory is unusable.
+package health
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"backend/internal/logging"
+
+	"github.com/go-chi/chi"
+)
+
+// Check is one thing a probe depends on.
+type Check struct {
+	Name string
+	Run  func() error
+}
+
+// Checks builds its list when asked, for dependencies that come and go such
+// as open rooms.
+type Checks func() []Check
+
+// Handler runs every check, answering 200 if they all pass and 503 if any
+// fails, with a line per check either way.
+func Handler(checks Checks) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		lines := []string{}
+		status := http.StatusOK
+
+		for _, check := range checks() {
+			if err := check.Run(); err != nil {
+				logging.From(r.Context()).Warn("Health check failed", "check", check.Name, "err", err)
+				lines = append(lines, fmt.Sprintf("%s: %v", check.Name, err))
+				status = http.StatusServiceUnavailable
+			} else {
+				lines = append(lines, fmt.Sprintf("%s: ok", check.Name))
+			}
+		}
+
+		// Answering at all is all that is asked of a service without
+		// dependencies.
+		if len(lines) == 0 {
+			lines = append(lines, "ok")
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(status)
+		fmt.Fprintln(w, strings.Join(lines, "\n"))
+	}
+}
+
+// Mount serves /healthz, running the liveness checks, and /readyz, running
+// those and the readiness checks, on r. Either may be nil. They are mounted
+// ahead of authentication: probes come from the orchestrator, which presents
+// no credentials, and answer with no more than the names of the checks.
+func Mount(r chi.Router, liveness, readiness Checks) {
+	r.Get("/healthz", Handler(All(liveness)))
+	r.Get("/readyz", Handler(All(liveness, readiness)))
+}
+
+// All is every check of each list, skipping nil ones.
+func All(lists ...Checks) Checks {
+	return func() []Check {
+		all := []Check{}
+		for _, list := range lists {
+			if list != nil {
+				all = append(all, list()...)
+			}
+		}
+		return all
+	}
+}
+
+// Writable checks that a file can be created in the directory, creating the
+// directory first if need be, as the stores do.
+func Writable(directory string) Check {
+	return Check{
+		Name: "directory",
+		Run: func() error {
+			if err := os.MkdirAll(directory, 0755); err != nil {
+				return err
+			}
+
+			file, err := ioutil.TempFile(directory, ".healthz-")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(file.Name())
+
+			if _, err := file.Write([]byte("ok")); err != nil {
+				file.Close()
+				return err
+			}
+			return file.Close()
+		},
+	}
+}
@@ -0,0 +1,102 @@
+package health
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/go-chi/chi"
+)
+
+func probe(checks Checks) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	Handler(checks).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	return w
+}
+
+func TestHandler(t *testing.T) {
+	passing := Check{Name: "passing", Run: func() error { return nil }}
+	failing := Check{Name: "failing", Run: func() error { return errors.New("broken") }}
+
+	tests := []struct {
+		checks []Check
+		status int
+		body   string
+	}{
+		{nil, http.StatusOK, "ok\n"},
+		{[]Check{passing}, http.StatusOK, "passing: ok\n"},
+		{[]Check{passing, failing}, http.StatusServiceUnavailable, "passing: ok\nfailing: broken\n"},
+	}
+
+	for _, test := range tests {
+		checks := test.checks
+		w := probe(func() []Check { return checks })
+
+		if w.Code != test.status || w.Body.String() != test.body {
+			t.Errorf("Want %d '%s'; Got %d '%s'", test.status, test.body, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	one := func() []Check { return []Check{{Name: "one", Run: func() error { return nil }}} }
+	two := func() []Check { return []Check{{Name: "two", Run: func() error { return nil }}} }
+
+	if w := probe(All(one, two)); w.Body.String() != "one: ok\ntwo: ok\n" {
+		t.Errorf("Got '%s'", w.Body.String())
+	}
+}
+
+func TestWritable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "health-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Created if missing.
+	if err := Writable(path.Join(dir, "store")).Run(); err != nil {
+		t.Errorf("Want a missing directory created; Got %v", err)
+	}
+
+	files, _ := ioutil.ReadDir(path.Join(dir, "store"))
+	if len(files) != 0 {
+		t.Errorf("Want the check to clean up; Got %d files left", len(files))
+	}
+
+	// A file in the way.
+	blocked := path.Join(dir, "blocked")
+	ioutil.WriteFile(blocked, []byte{}, 0644)
+
+	if err := Writable(blocked).Run(); err == nil {
+		t.Errorf("Want a failure when the directory is a file")
+	}
+}
+
+func TestMount(t *testing.T) {
+	failing := func() []Check {
+		return []Check{{Name: "directory", Run: func() error { return errors.New("read-only") }}}
+	}
+
+	r := chi.NewRouter()
+	Mount(r, nil, failing)
+
+	tests := []struct {
+		path   string
+		status int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/readyz", http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.path, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: Want %d; Got %d '%s'", test.path, test.status, w.Code, w.Body.String())
+		}
+	}
+}
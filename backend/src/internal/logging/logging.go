@@ -0,0 +1,333 @@
+// Package logging writes leveled, structured logs as logfmt or JSON lines,
+// for shipping to a log collector and filtering there.
+//
+// A Logger carries fields, such as the component, room or client, that are
+// added to every line it writes:
+//
+//	log := logging.Default().With("component", "messaging", "room", id)
+//	log.Info("Compacted room", "records", n, "bytes", size)
+//
+// Loggers are safe for concurrent use, and a nil *Logger writes to Default.
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = map[Level]string{
+	LevelDebug: "debug",
+	LevelInfo:  "info",
+	LevelWarn:  "warn",
+	LevelError: "error",
+}
+
+func (l Level) String() string {
+	if name, ok := levelNames[l]; ok {
+		return name
+	}
+	return "Level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// ParseLevel parses the name of a level: debug, info, warn or error.
+func ParseLevel(name string) (Level, error) {
+	for level, levelName := range levelNames {
+		if strings.EqualFold(name, levelName) {
+			return level, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level '%s'", name)
+}
+
+type Format int
+
+const (
+	// key=value pairs, see https://brandur.org/logfmt.
+	FormatLogfmt Format = iota
+
+	// A JSON object per line.
+	FormatJSON
+)
+
+var formatNames = map[Format]string{
+	FormatLogfmt: "logfmt",
+	FormatJSON:   "json",
+}
+
+func (f Format) String() string {
+	if name, ok := formatNames[f]; ok {
+		return name
+	}
+	return "Format(" + strconv.Itoa(int(f)) + ")"
+}
+
+// ParseFormat parses the name of a format: logfmt or json.
+func ParseFormat(name string) (Format, error) {
+	for format, formatName := range formatNames {
+		if strings.EqualFold(name, formatName) {
+			return format, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log format '%s'", name)
+}
+
+// Where lines go, shared by a logger and everything derived from it.
+type sink struct {
+	mutex  sync.Mutex
+	out    io.Writer
+	format Format
+	level  Level
+	now    func() time.Time
+}
+
+type Logger struct {
+	sink *sink
+
+	// Key, value pairs added to every line.
+	fields []interface{}
+}
+
+// New writes lines of at least the given level to out.
+func New(out io.Writer, format Format, level Level) *Logger {
+	return &Logger{sink: &sink{out: out, format: format, level: level, now: time.Now}}
+}
+
+var defaultLogger atomic.Value
+
+func init() {
+	defaultLogger.Store(New(os.Stderr, FormatLogfmt, LevelInfo))
+}
+
+// Default is the logger of anything not given one: info and above, as logfmt,
+// to stderr until SetDefault.
+func Default() *Logger {
+	return defaultLogger.Load().(*Logger)
+}
+
+func SetDefault(l *Logger) {
+	defaultLogger.Store(l)
+}
+
+func (l *Logger) orDefault() *Logger {
+	if l == nil {
+		return Default()
+	}
+	return l
+}
+
+// With returns a logger adding the key, value pairs to every line. A key the
+// logger already has takes the new value, so that a collector logging for a
+// room is the collector component rather than the room's.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	l = l.orDefault()
+
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(fields, l.fields...)
+
+next:
+	for idx := 0; idx+1 < len(keyvals); idx = idx + 2 {
+		for existing := 0; existing+1 < len(fields); existing = existing + 2 {
+			if fields[existing] == keyvals[idx] {
+				fields[existing+1] = keyvals[idx+1]
+				continue next
+			}
+		}
+		fields = append(fields, keyvals[idx], keyvals[idx+1])
+	}
+	if len(keyvals)%2 != 0 {
+		fields = append(fields, keyvals[len(keyvals)-1])
+	}
+
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// Enabled reports whether lines of the level are written, for skipping work
+// that only feeds the log.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.orDefault().sink.level
+}
+
+func (l *Logger) Debug(msg string, keyvals ...interface{}) {
+	l.Log(LevelDebug, msg, keyvals...)
+}
+
+func (l *Logger) Info(msg string, keyvals ...interface{}) {
+	l.Log(LevelInfo, msg, keyvals...)
+}
+
+func (l *Logger) Warn(msg string, keyvals ...interface{}) {
+	l.Log(LevelWarn, msg, keyvals...)
+}
+
+func (l *Logger) Error(msg string, keyvals ...interface{}) {
+	l.Log(LevelError, msg, keyvals...)
+}
+
+// Log writes a line of the time, level, message, the logger's fields and then
+// the key, value pairs.
+func (l *Logger) Log(level Level, msg string, keyvals ...interface{}) {
+	l = l.orDefault()
+	if !l.Enabled(level) {
+		return
+	}
+
+	s := l.sink
+
+	fields := make([]interface{}, 0, 6+len(l.fields)+len(keyvals))
+	fields = append(fields, "time", s.now().UTC().Format("2006-01-02T15:04:05.000Z07:00"), "level", level.String(), "msg", msg)
+	fields = append(fields, l.fields...)
+	fields = append(fields, keyvals...)
+	if len(fields)%2 != 0 {
+		// A value without a key is still worth seeing.
+		fields = append(fields[:len(fields)-1], "extra", fields[len(fields)-1])
+	}
+
+	var line bytes.Buffer
+	if s.format == FormatJSON {
+		encodeJSON(&line, fields)
+	} else {
+		encodeLogfmt(&line, fields)
+	}
+	line.WriteByte('\n')
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.out.Write(line.Bytes())
+}
+
+func encodeLogfmt(buf *bytes.Buffer, fields []interface{}) {
+	for idx := 0; idx < len(fields); idx = idx + 2 {
+		if idx > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString(logfmtValue(fmt.Sprint(fields[idx])))
+		buf.WriteByte('=')
+		buf.WriteString(logfmtValue(text(fields[idx+1])))
+	}
+}
+
+func logfmtValue(s string) string {
+	if s == "" {
+		return `""`
+	}
+	for _, r := range s {
+		if r == ' ' || r == '=' || r == '"' || r == '\\' || !unicode.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
+
+func encodeJSON(buf *bytes.Buffer, fields []interface{}) {
+	buf.WriteByte('{')
+	for idx := 0; idx < len(fields); idx = idx + 2 {
+		if idx > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := json.Marshal(fmt.Sprint(fields[idx]))
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(jsonValue(fields[idx+1]))
+	}
+	buf.WriteByte('}')
+}
+
+func jsonValue(v interface{}) []byte {
+	switch value := v.(type) {
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		encoded, _ := json.Marshal(value)
+		return encoded
+	case float32:
+		if !math.IsInf(float64(value), 0) && !math.IsNaN(float64(value)) {
+			encoded, _ := json.Marshal(value)
+			return encoded
+		}
+	case float64:
+		if !math.IsInf(value, 0) && !math.IsNaN(value) {
+			encoded, _ := json.Marshal(value)
+			return encoded
+		}
+	}
+	encoded, _ := json.Marshal(text(v))
+	return encoded
+}
+
+// text is how a value reads in a line: errors by their message, durations
+// and other Stringers as they print themselves.
+func text(v interface{}) string {
+	switch value := v.(type) {
+	case string:
+		return value
+	case error:
+		return value.Error()
+	case fmt.Stringer:
+		return value.String()
+	}
+	return fmt.Sprint(v)
+}
+
+// Writer logs each line written to it at the level, such as for the standard
+// library's log package or http.Server's ErrorLog.
+func (l *Logger) Writer(level Level) io.Writer {
+	return lineWriter{logger: l.orDefault(), level: level}
+}
+
+type lineWriter struct {
+	logger *Logger
+	level  Level
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		w.logger.Log(w.level, line)
+	}
+	return len(p), nil
+}
+
+type contextKey struct{}
+
+// WithLogger returns a context whose requests log to l, see From.
+func WithLogger(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// From is the logger of a request's context, or Default if it has none.
+func From(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// Middleware has the requests passing through log to l, along with their
+// remote address.
+func Middleware(l *Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := WithLogger(r.Context(), l.With("remote", r.RemoteAddr))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
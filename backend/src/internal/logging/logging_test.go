@@ -0,0 +1,145 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestLogger(format Format, level Level) (*Logger, *bytes.Buffer) {
+	var out bytes.Buffer
+	l := New(&out, format, level)
+	l.sink.now = func() time.Time { return time.Date(2020, 5, 17, 12, 30, 0, 0, time.UTC) }
+	return l, &out
+}
+
+func TestLogfmt(t *testing.T) {
+	l, out := newTestLogger(FormatLogfmt, LevelInfo)
+
+	l.With("component", "messaging", "room", "").Warn("Disconnecting client",
+		"client", uint16(3), "err", errors.New("rate limit exceeded"), "wait", 1500*time.Millisecond)
+
+	want := `time=2020-05-17T12:30:00.000Z level=warn msg="Disconnecting client" component=messaging room="" ` +
+		`client=3 err="rate limit exceeded" wait=1.5s` + "\n"
+	if out.String() != want {
+		t.Errorf("Want %s; Got %s", want, out.String())
+	}
+}
+
+func TestWithReplaces(t *testing.T) {
+	l, out := newTestLogger(FormatLogfmt, LevelInfo)
+
+	l.With("component", "messaging", "room", "lobby").With("component", "collector").Info("hello")
+
+	if want := "msg=hello component=collector room=lobby\n"; !bytes.HasSuffix(out.Bytes(), []byte(want)) {
+		t.Errorf("Want %s; Got %s", want, out.String())
+	}
+}
+
+func TestJSON(t *testing.T) {
+	l, out := newTestLogger(FormatJSON, LevelDebug)
+
+	l.With("component", "collector").Debug("Wrote \"record\"", "bytes", 120, "ok", true, "ratio", 0.5, "err", errors.New("none"))
+
+	want := `{"time":"2020-05-17T12:30:00.000Z","level":"debug","msg":"Wrote \"record\"",` +
+		`"component":"collector","bytes":120,"ok":true,"ratio":0.5,"err":"none"}` + "\n"
+	if out.String() != want {
+		t.Errorf("Want %s; Got %s", want, out.String())
+	}
+}
+
+func TestLevels(t *testing.T) {
+	l, out := newTestLogger(FormatLogfmt, LevelWarn)
+
+	l.Debug("hidden")
+	l.Info("hidden")
+	l.Warn("shown")
+	l.Error("shown")
+
+	if got := bytes.Count(out.Bytes(), []byte("shown")); got != 2 || bytes.Contains(out.Bytes(), []byte("hidden")) {
+		t.Errorf("Want only warnings and errors; Got %s", out.String())
+	}
+
+	if l.Enabled(LevelInfo) || !l.Enabled(LevelError) {
+		t.Errorf("Enabled disagrees with the level")
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, name := range []string{"debug", "info", "WARN", "error"} {
+		if _, err := ParseLevel(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("Want an error for an unknown level")
+	}
+
+	for _, name := range []string{"logfmt", "JSON"} {
+		if _, err := ParseFormat(name); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Errorf("Want an error for an unknown format")
+	}
+}
+
+func TestOddFields(t *testing.T) {
+	l, out := newTestLogger(FormatLogfmt, LevelInfo)
+
+	l.Info("odd", "key")
+
+	if want := "extra=key\n"; !bytes.HasSuffix(out.Bytes(), []byte(want)) {
+		t.Errorf("Want a trailing %s; Got %s", want, out.String())
+	}
+}
+
+func TestNilUsesDefault(t *testing.T) {
+	l, out := newTestLogger(FormatLogfmt, LevelInfo)
+
+	previous := Default()
+	SetDefault(l)
+	defer SetDefault(previous)
+
+	var unset *Logger
+	unset.With("component", "vector").Info("hello")
+
+	if !bytes.Contains(out.Bytes(), []byte("msg=hello component=vector")) {
+		t.Errorf("Got %s", out.String())
+	}
+}
+
+func TestWriter(t *testing.T) {
+	l, out := newTestLogger(FormatLogfmt, LevelInfo)
+
+	std := log.New(l.Writer(LevelWarn), "", 0)
+	std.Printf("http: TLS handshake error from %s", "10.0.0.1:5000")
+
+	want := `level=warn msg="http: TLS handshake error from 10.0.0.1:5000"`
+	if !bytes.Contains(out.Bytes(), []byte(want)) {
+		t.Errorf("Want %s; Got %s", want, out.String())
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, out := newTestLogger(FormatLogfmt, LevelInfo)
+
+	handler := Middleware(l.With("component", "vector"))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		From(r.Context()).Info("handled")
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:5000"
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	want := fmt.Sprintf("msg=handled component=vector remote=%s", r.RemoteAddr)
+	if !bytes.Contains(out.Bytes(), []byte(want)) {
+		t.Errorf("Want %s; Got %s", want, out.String())
+	}
+}
@@ -7,30 +7,48 @@ import "strconv"
 type Action int8
 
 const (
-	ActionUp     Action = 0
-	ActionDown   Action = 1
-	ActionMove   Action = 2
-	ActionClear  Action = 3
-	ActionCursor Action = 4
-	ActionCancel Action = 5
+	ActionUp       Action = 0
+	ActionDown     Action = 1
+	ActionMove     Action = 2
+	ActionClear    Action = 3
+	ActionCursor   Action = 4
+	ActionCancel   Action = 5
+	ActionPolyline Action = 6
+	ActionWelcome  Action = 7
+	ActionLeave    Action = 8
+	ActionUndo     Action = 9
+	ActionRedo     Action = 10
+	ActionErase    Action = 11
 )
 
 var EnumNamesAction = map[Action]string{
-	ActionUp:     "Up",
-	ActionDown:   "Down",
-	ActionMove:   "Move",
-	ActionClear:  "Clear",
-	ActionCursor: "Cursor",
-	ActionCancel: "Cancel",
+	ActionUp:       "Up",
+	ActionDown:     "Down",
+	ActionMove:     "Move",
+	ActionClear:    "Clear",
+	ActionCursor:   "Cursor",
+	ActionCancel:   "Cancel",
+	ActionPolyline: "Polyline",
+	ActionWelcome:  "Welcome",
+	ActionLeave:    "Leave",
+	ActionUndo:     "Undo",
+	ActionRedo:     "Redo",
+	ActionErase:    "Erase",
 }
 
 var EnumValuesAction = map[string]Action{
-	"Up":     ActionUp,
-	"Down":   ActionDown,
-	"Move":   ActionMove,
-	"Clear":  ActionClear,
-	"Cursor": ActionCursor,
-	"Cancel": ActionCancel,
+	"Up":       ActionUp,
+	"Down":     ActionDown,
+	"Move":     ActionMove,
+	"Clear":    ActionClear,
+	"Cursor":   ActionCursor,
+	"Cancel":   ActionCancel,
+	"Polyline": ActionPolyline,
+	"Welcome":  ActionWelcome,
+	"Leave":    ActionLeave,
+	"Undo":     ActionUndo,
+	"Redo":     ActionRedo,
+	"Erase":    ActionErase,
 }
 
 func (v Action) String() string {
@@ -6,6 +6,27 @@ import (
 	flatbuffers "github.com/google/flatbuffers/go"
 )
 
+type CoordinateT struct {
+	X float32
+	Y float32
+}
+
+func (t *CoordinateT) Pack(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	if t == nil { return 0 }
+	return CreateCoordinate(builder, t.X, t.Y)
+}
+func (rcv *Coordinate) UnPackTo(t *CoordinateT) {
+	t.X = rcv.X()
+	t.Y = rcv.Y()
+}
+
+func (rcv *Coordinate) UnPack() *CoordinateT {
+	if rcv == nil { return nil }
+	t := &CoordinateT{}
+	rcv.UnPackTo(t)
+	return t
+}
+
 type Coordinate struct {
 	_tab flatbuffers.Struct
 }
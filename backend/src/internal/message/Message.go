@@ -6,6 +6,48 @@ import (
 	flatbuffers "github.com/google/flatbuffers/go"
 )
 
+type MessageT struct {
+	ClientId uint16
+	Id int32
+	Data *CoordinateT
+	Action Action
+	Sequence uint64
+	Polyline *PolylineT
+	Target uint16
+}
+
+func (t *MessageT) Pack(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
+	if t == nil { return 0 }
+	polylineOffset := t.Polyline.Pack(builder)
+	MessageStart(builder)
+	MessageAddClientId(builder, t.ClientId)
+	MessageAddId(builder, t.Id)
+	dataOffset := t.Data.Pack(builder)
+	MessageAddData(builder, dataOffset)
+	MessageAddAction(builder, t.Action)
+	MessageAddSequence(builder, t.Sequence)
+	MessageAddPolyline(builder, polylineOffset)
+	MessageAddTarget(builder, t.Target)
+	return MessageEnd(builder)
+}
+
+func (rcv *Message) UnPackTo(t *MessageT) {
+	t.ClientId = rcv.ClientId()
+	t.Id = rcv.Id()
+	t.Data = rcv.Data(nil).UnPack()
+	t.Action = rcv.Action()
+	t.Sequence = rcv.Sequence()
+	t.Polyline = rcv.Polyline(nil).UnPack()
+	t.Target = rcv.Target()
+}
+
+func (rcv *Message) UnPack() *MessageT {
+	if rcv == nil { return nil }
+	t := &MessageT{}
+	rcv.UnPackTo(t)
+	return t
+}
+
 type Message struct {
 	_tab flatbuffers.Table
 }
@@ -75,8 +117,45 @@ func (rcv *Message) MutateAction(n Action) bool {
 	return rcv._tab.MutateInt8Slot(10, int8(n))
 }
 
+func (rcv *Message) Sequence() uint64 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(12))
+	if o != 0 {
+		return rcv._tab.GetUint64(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *Message) MutateSequence(n uint64) bool {
+	return rcv._tab.MutateUint64Slot(12, n)
+}
+
+func (rcv *Message) Polyline(obj *Polyline) *Polyline {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(14))
+	if o != 0 {
+		x := rcv._tab.Indirect(o + rcv._tab.Pos)
+		if obj == nil {
+			obj = new(Polyline)
+		}
+		obj.Init(rcv._tab.Bytes, x)
+		return obj
+	}
+	return nil
+}
+
+func (rcv *Message) Target() uint16 {
+	o := flatbuffers.UOffsetT(rcv._tab.Offset(16))
+	if o != 0 {
+		return rcv._tab.GetUint16(o + rcv._tab.Pos)
+	}
+	return 0
+}
+
+func (rcv *Message) MutateTarget(n uint16) bool {
+	return rcv._tab.MutateUint16Slot(16, n)
+}
+
 func MessageStart(builder *flatbuffers.Builder) {
-	builder.StartObject(4)
+	builder.StartObject(7)
 }
 func MessageAddClientId(builder *flatbuffers.Builder, clientId uint16) {
 	builder.PrependUint16Slot(0, clientId, 0)
@@ -90,6 +169,15 @@ func MessageAddData(builder *flatbuffers.Builder, data flatbuffers.UOffsetT) {
 func MessageAddAction(builder *flatbuffers.Builder, action Action) {
 	builder.PrependInt8Slot(3, int8(action), 0)
 }
+func MessageAddSequence(builder *flatbuffers.Builder, sequence uint64) {
+	builder.PrependUint64Slot(4, sequence, 0)
+}
+func MessageAddPolyline(builder *flatbuffers.Builder, polyline flatbuffers.UOffsetT) {
+	builder.PrependUOffsetTSlot(5, flatbuffers.UOffsetT(polyline), 0)
+}
+func MessageAddTarget(builder *flatbuffers.Builder, target uint16) {
+	builder.PrependUint16Slot(6, target, 0)
+}
 func MessageEnd(builder *flatbuffers.Builder) flatbuffers.UOffsetT {
 	return builder.EndObject()
 }
@@ -1,93 +1,238 @@
 package messaging
 
 import (
-	"log"
 	"time"
 
+	"backend/internal/logging"
+
 	"github.com/gorilla/websocket"
 )
 
 type Client struct {
-	hub      *Hub
-	conn     *websocket.Conn
-	send     chan []byte
+	hub  *Hub
+	conn *websocket.Conn
+	send chan []byte
+
+	settings Connection
+
+	// Assigned by the hub on register, and stamped on every message sent.
+	id uint16
+
+	// Closed by the hub once it has registered the client, or refused it,
+	// so that the pumps start with the client's id in its log.
+	joined chan struct{}
+
+	// Chunks of history, closed once the whole playback has been queued.
 	playback chan [][]byte
+
+	// Closed when writePump stops, so playback stops producing chunks.
+	quit chan struct{}
+
+	// The last sequence number the client saw before reconnecting, or 0.
+	since uint64
+
+	// Whether playback sends completed strokes as polylines.
+	coalesce bool
+
+	// What the client may send, see Role.
+	role Role
+
+	// Applies the rate limits to everything the client sends.
+	limiter *limiter
+
+	// Logs with the client's room, remote address and, once registered,
+	// id.
+	log *logging.Logger
+
+	// Called once the client has left its hub.
+	leave func()
 }
 
 type InboundPayload struct {
-	Source  *Client
-	Payload *[]byte
+	Source   *Client
+	Payload  *[]byte
+	Received time.Time
 }
 
-const (
+// Connection settings of each client.
+type Connection struct {
 	// Time allowed to write a message to the peer.
-	writeWait = 10 * time.Second
+	WriteWait time.Duration
 
 	// Time allowed to read the next pong message from the peer.
-	pongWait = 10 * time.Second
-
-	// Send pings to peer with this period. Must be less than pongWait.
-	pingPeriod = (pongWait * 9) / 10
+	PongWait time.Duration
 
 	// Maximum message size allowed from peer.
-	maxMessageSize = 1024 * 10
-)
+	MaxMessageSize int64
+
+	// Buffers of the websocket, in bytes.
+	ReadBufferSize  int
+	WriteBufferSize int
+
+	// Messages queued for the peer before it is considered too slow and
+	// disconnected.
+	SendBuffer int
+}
+
+var DefaultConnection = Connection{
+	WriteWait:       10 * time.Second,
+	PongWait:        10 * time.Second,
+	MaxMessageSize:  1024 * 10,
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	SendBuffer:      1028,
+}
+
+// withDefaults fills in the fields left at zero from DefaultConnection.
+func (c Connection) withDefaults() Connection {
+	if c.WriteWait <= 0 {
+		c.WriteWait = DefaultConnection.WriteWait
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = DefaultConnection.PongWait
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = DefaultConnection.MaxMessageSize
+	}
+	if c.ReadBufferSize <= 0 {
+		c.ReadBufferSize = DefaultConnection.ReadBufferSize
+	}
+	if c.WriteBufferSize <= 0 {
+		c.WriteBufferSize = DefaultConnection.WriteBufferSize
+	}
+	if c.SendBuffer <= 0 {
+		c.SendBuffer = DefaultConnection.SendBuffer
+	}
+	return c
+}
+
+// Send pings to peer with this period. Must be less than PongWait.
+func (c Connection) pingPeriod() time.Duration {
+	return (c.PongWait * 9) / 10
+}
+
+// Live messages held back while playback is still being written. A client
+// that falls further behind than this is disconnected.
+const maxPendingDuringPlayback = 4096
 
 func (c *Client) pongHandler(string) error {
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetReadDeadline(time.Now().Add(c.settings.PongWait))
 	return nil
 }
 
 func (c *Client) readPump() {
 	defer func() {
-		c.hub.unregister <- c
+		c.limiter.close()
+		select {
+		case c.hub.unregister <- c:
+		case <-c.hub.stopped:
+		}
 		c.conn.Close()
+		c.leave()
 	}()
 
-	c.conn.SetReadLimit(maxMessageSize)
-	c.conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.conn.SetReadLimit(c.settings.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(c.settings.PongWait))
 	c.conn.SetPongHandler(c.pongHandler)
 
 	for {
 		_, message, err := c.conn.ReadMessage()
 		if err != nil {
-			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("Unexpected error: %v", err)
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway,
+				websocket.CloseNoStatusReceived, websocket.CloseAbnormalClosure, websocket.CloseServiceRestart) {
+				c.log.Warn("Client closed unexpectedly", "err", err)
+			} else {
+				c.log.Debug("Client disconnected", "err", err)
 			}
-			log.Printf("Expected error: %v", err)
 			break
 		}
 
-		c.hub.inbound <- &InboundPayload{Source: c, Payload: &message}
+		action, err := validate(message, c.hub.extent)
+		if err != nil {
+			c.log.Warn("Disconnecting client after invalid message", "err", err)
+			clientsDisconnected.With(reasonInvalidMessage).Inc()
+			c.closeWith(websocket.CloseInvalidFramePayloadData, err.Error())
+			break
+		}
+
+		countInbound(action, message)
+
+		inbound := &InboundPayload{Source: c, Payload: &message, Received: time.Now()}
+
+		if err := c.limiter.submit(action, inbound); err == errRateLimited {
+			c.log.Warn("Disconnecting client over rate limit", "err", err)
+			clientsDisconnected.With(reasonRateLimited).Inc()
+			c.closeWith(websocket.ClosePolicyViolation, err.Error())
+			break
+		} else if err != nil {
+			return
+		}
 	}
 }
 
+// closeWith tells the client why it is being disconnected.
+func (c *Client) closeWith(code int, reason string) {
+	closing := websocket.FormatCloseMessage(code, reason)
+	c.conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(c.settings.WriteWait))
+}
+
+// forward hands a message to the hub, see limiter.
+func (c *Client) forward(payload *InboundPayload) bool {
+	select {
+	case c.hub.inbound <- payload:
+		return true
+	case <-c.hub.stopped:
+		return false
+	}
+}
+
+func (c *Client) write(messages [][]byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(c.settings.WriteWait))
+	for idx := range messages {
+		if err := c.conn.WriteMessage(websocket.BinaryMessage, messages[idx]); err != nil {
+			return err
+		}
+		countOutbound(messages[idx])
+	}
+	return nil
+}
+
+// writePump writes playback before any live message: live messages that
+// arrive while playback is in progress are held in order and written once
+// the last chunk of history has gone out. Pings are interleaved with chunks.
 func (c *Client) writePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.settings.pingPeriod())
 
 	defer func() {
 		ticker.Stop()
 		c.conn.Close()
+		close(c.quit)
 	}()
 
+	playback := c.playback
+	pending := [][]byte{}
+
 	for {
 		select {
-		case messages, ok := <-c.playback:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+		case chunk, ok := <-playback:
 			if !ok {
-				// The hub closed the channel.
-				c.conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-			for idx := range messages {
-				if err := c.conn.WriteMessage(websocket.BinaryMessage, messages[idx]); err != nil {
-					log.Printf("Unable to write message to websocket: %v\n", err)
-					break
+				// Playback is complete; catch up on what arrived meanwhile.
+				playback = nil
+				if err := c.write(pending); err != nil {
+					c.log.Debug("Failed to write to websocket", "err", err)
+					return
 				}
+				pending = nil
+				break
 			}
-			break
+
+			if err := c.write(chunk); err != nil {
+				c.log.Debug("Failed to write playback to websocket", "err", err)
+				return
+			}
+
 		case bytes, ok := <-c.send:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(c.settings.WriteWait))
 
 			if !ok {
 				// The hub closed the channel.
@@ -95,14 +240,24 @@ func (c *Client) writePump() {
 				return
 			}
 
-			if err := c.conn.WriteMessage(websocket.BinaryMessage, bytes); err != nil {
-				log.Printf("Unable to write message to websocket: %v\n", err)
+			if playback != nil {
+				if len(pending) >= maxPendingDuringPlayback {
+					c.log.Warn("Disconnecting client that fell behind during playback", "pending", len(pending))
+					clientsDisconnected.With(reasonPlaybackBacklog).Inc()
+					return
+				}
+				pending = append(pending, bytes)
+				break
+			}
+
+			if err := c.write([][]byte{bytes}); err != nil {
+				c.log.Debug("Failed to write to websocket", "err", err)
 			}
 
 		case <-ticker.C:
-			c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+			c.conn.SetWriteDeadline(time.Now().Add(c.settings.WriteWait))
 			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
-				log.Printf("Unable to write PingMessage: %v\n", err)
+				c.log.Debug("Failed to ping client", "err", err)
 				return
 			}
 		}
@@ -0,0 +1,98 @@
+package messaging
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The reason clients are given for being disconnected by drain.
+const drainReason = "server restarting"
+
+// connect counts a client in, unless the rooms are draining.
+func (rs *Rooms) connect() bool {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if rs.draining || rs.closed {
+		return false
+	}
+
+	rs.connected = rs.connected + 1
+	return true
+}
+
+// disconnect counts a client out, once it has left its hub.
+func (rs *Rooms) disconnect() {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.connected = rs.connected - 1
+	if rs.draining && rs.connected == 0 {
+		close(rs.drained)
+	}
+}
+
+// drain sends every client a close frame saying the server is restarting and
+// waits for them to leave. A client leaves once its read pump has handed all
+// it read, and anything its limiter held, to the hub, and hubs hand messages
+// to their collectors before taking the next; so once every client has left,
+// stopping the hubs and then the collectors loses nothing. Clients still
+// connected after timeout are cut off.
+func (rs *Rooms) drain(timeout time.Duration) {
+	rs.mutex.Lock()
+	if rs.draining || rs.closed {
+		rs.mutex.Unlock()
+		return
+	}
+	rs.draining = true
+	if rs.connected == 0 {
+		close(rs.drained)
+	}
+	hubs := make([]*Hub, 0, len(rs.rooms))
+	for _, r := range rs.rooms {
+		hubs = append(hubs, r.hub)
+	}
+	rs.mutex.Unlock()
+
+	log := rs.store.logger()
+
+	clients := []*Client{}
+	for _, hub := range hubs {
+		clients = append(clients, hub.registered()...)
+	}
+
+	if len(clients) > 0 {
+		log.Info("Disconnecting clients", "clients", len(clients))
+	}
+
+	for _, client := range clients {
+		go client.closeWith(websocket.CloseServiceRestart, drainReason)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	select {
+	case <-rs.drained:
+		return
+	case <-deadline.C:
+	}
+
+	// Their read pumps stop, and the clients leave, as soon as the
+	// connections close.
+	for _, hub := range hubs {
+		for _, client := range hub.registered() {
+			log.Warn("Cutting off client that did not close", "remote", client.conn.RemoteAddr().String())
+			client.conn.Close()
+		}
+	}
+
+	deadline.Reset(timeout)
+
+	select {
+	case <-rs.drained:
+	case <-deadline.C:
+		log.Error("Gave up waiting for clients to leave")
+	}
+}
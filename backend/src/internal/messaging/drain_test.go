@@ -0,0 +1,122 @@
+package messaging
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/collector"
+	"backend/internal/message"
+
+	"github.com/gorilla/websocket"
+)
+
+func openTestService(t *testing.T, store *Store) (*Service, *httptest.Server) {
+	dir, err := ioutil.TempDir("", "messaging-drain-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Directory = dir
+
+	service, err := store.Open()
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return service, httptest.NewServer(service.Handler())
+}
+
+func TestDrainKeepsLastStrokes(t *testing.T) {
+	store := &Store{ShutdownTimeout: time.Second}
+	service, server := openTestService(t, store)
+	defer os.RemoveAll(store.Directory)
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/rooms/board"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	const strokes = 200
+	for idx := 0; idx < strokes; idx++ {
+		send(t, conn, buildMessage(0, message.ActionMove, float32(idx), 0))
+	}
+
+	// Straight after the last stroke, as on a deploy.
+	server.Close()
+	closed := make(chan struct{})
+	go func() {
+		service.Close()
+		close(closed)
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		if !websocket.IsCloseError(err, websocket.CloseServiceRestart) || !strings.Contains(err.Error(), drainReason) {
+			t.Errorf("Want a close saying the server is restarting; Got %v", err)
+		}
+		break
+	}
+
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Close did not return once the client left.")
+	}
+
+	c, err := collector.NewCollector(path.Join(store.Directory, "board"), 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Start()
+	defer func() {
+		c.Stop()
+		<-c.Finished
+	}()
+
+	records, err := c.Read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != strokes {
+		t.Errorf("Want all %d strokes stored; Got %d", strokes, len(records))
+	}
+}
+
+func TestDrainCutsOffUnresponsiveClients(t *testing.T) {
+	store := &Store{ShutdownTimeout: 100 * time.Millisecond}
+	service, server := openTestService(t, store)
+	defer os.RemoveAll(store.Directory)
+
+	// Never reads, so never answers the close frame.
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/rooms/board"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	server.Close()
+
+	started := time.Now()
+	service.Close()
+
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("Want the client cut off after the shutdown timeout; Took %v", elapsed)
+	}
+
+	if _, err := service.rooms.acquire("board"); err == nil {
+		t.Errorf("Want the rooms closed after draining.")
+	}
+}
@@ -1,28 +1,131 @@
 package messaging
 
 import (
-	"github.com/gorilla/websocket"
-	"log"
+	"fmt"
 	"net/http"
+	"strconv"
+
+	"backend/internal/logging"
+	"backend/internal/origins"
+
+	"github.com/go-chi/chi"
+	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
+func newUpgrader(settings Connection, allowed origins.List) *websocket.Upgrader {
+	return &websocket.Upgrader{
+		ReadBufferSize:  settings.ReadBufferSize,
+		WriteBufferSize: settings.WriteBufferSize,
+		CheckOrigin:     allowed.CheckOrigin,
+	}
 }
 
-func GetHandler(hub *Hub) http.HandlerFunc {
+func GetHandler(rooms *Rooms) http.HandlerFunc {
+	upgrader := newUpgrader(rooms.store.Connection.withDefaults(), rooms.store.Origins)
 	return func(w http.ResponseWriter, r *http.Request) {
-		conn, err := upgrader.Upgrade(w, r, nil)
-		if err != nil {
-			log.Printf("Message handler failed to upgrade GET to websocket: %s", err.Error())
+		serveRoom(rooms, upgrader, defaultRoom, w, r)
+	}
+}
+
+func GetRoomHandler(rooms *Rooms) http.HandlerFunc {
+	upgrader := newUpgrader(rooms.store.Connection.withDefaults(), rooms.store.Origins)
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := chi.URLParam(r, "id")
+		if !ValidRoomId(id) {
+			msg := fmt.Sprintf("Invalid room id '%s'.", id)
+			http.Error(w, msg, http.StatusBadRequest)
 			return
 		}
 
-		client := &Client{hub: hub, conn: conn, send: make(chan []byte, 1028), playback: make(chan [][]byte, 1)}
-		client.hub.register <- client
+		serveRoom(rooms, upgrader, id, w, r)
+	}
+}
+
+func serveRoom(rooms *Rooms, upgrader *websocket.Upgrader, id string, w http.ResponseWriter, r *http.Request) {
+	// A reconnecting client passes the last sequence number it received as
+	// ?since=N and is sent only what it missed.
+	since := uint64(0)
+	if value := r.URL.Query().Get("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			msg := fmt.Sprintf("Cannot resume from since = '%s'", value)
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
 
-		go client.writePump()
-		go client.readPump()
+	// Clients that understand ActionPolyline ask for ?playback=coalesced.
+	coalesce := false
+	switch mode := r.URL.Query().Get("playback"); mode {
+	case "", "raw":
+	case "coalesced":
+		coalesce = true
+	default:
+		msg := fmt.Sprintf("Unknown playback mode '%s'", mode)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
 	}
+
+	role, ok := rooms.store.roleFor(r, id)
+	if !ok {
+		msg := fmt.Sprintf("Not allowed in room '%s'.", id)
+		http.Error(w, msg, http.StatusForbidden)
+		return
+	}
+
+	log := logging.From(r.Context()).With("room", id)
+
+	if !rooms.connect() {
+		http.Error(w, "Server is restarting.", http.StatusServiceUnavailable)
+		return
+	}
+
+	room, err := rooms.acquire(id)
+	if err != nil {
+		log.Error("Failed to open room", "err", err)
+		http.Error(w, "Failed to open room.", http.StatusInternalServerError)
+		rooms.disconnect()
+		return
+	}
+
+	// The upgrader has already answered, and said why.
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debug("Failed to upgrade to websocket", "err", err)
+		rooms.release(room)
+		rooms.disconnect()
+		return
+	}
+
+	settings := rooms.store.Connection.withDefaults()
+	client := &Client{
+		hub:      room.hub,
+		conn:     conn,
+		settings: settings,
+		send:     make(chan []byte, settings.SendBuffer),
+		playback: make(chan [][]byte, 1),
+		quit:     make(chan struct{}),
+		joined:   make(chan struct{}),
+		since:    since,
+		coalesce: coalesce,
+		role:     role,
+		log:      log,
+		leave: func() {
+			rooms.release(room)
+			rooms.disconnect()
+		},
+	}
+	client.limiter = newLimiter(rooms.store.Limits, client.forward)
+
+	select {
+	case client.hub.register <- client:
+	case <-client.hub.stopped:
+		conn.Close()
+		client.leave()
+		return
+	}
+	<-client.joined
+
+	go client.writePump()
+	go client.readPump()
 }
@@ -0,0 +1,292 @@
+package messaging
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/logging"
+	"backend/internal/message"
+	"backend/internal/origins"
+
+	"github.com/gorilla/websocket"
+)
+
+type testServer struct {
+	t      *testing.T
+	dir    string
+	rooms  *Rooms
+	server *httptest.Server
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newTestServerWith(t, &Store{})
+}
+
+// newTestServerWith serves the given store from a temporary directory.
+func newTestServerWith(t *testing.T, store *Store) *testServer {
+	dir, err := ioutil.TempDir("", "messaging-handler-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store.Directory = dir
+	rooms := newRooms(store)
+
+	return &testServer{t: t, dir: dir, rooms: rooms, server: httptest.NewServer(store.routes(rooms))}
+}
+
+func (ts *testServer) Close() {
+	ts.server.Close()
+	ts.rooms.closeAll()
+	os.RemoveAll(ts.dir)
+}
+
+func (ts *testServer) url(path string) string {
+	return "ws" + strings.TrimPrefix(ts.server.URL, "http") + path
+}
+
+func (ts *testServer) dial(path string) *websocket.Conn {
+	conn, _ := ts.join(path)
+	return conn
+}
+
+// join connects and reads the welcome, returning the id the hub assigned.
+func (ts *testServer) join(path string) (*websocket.Conn, uint16) {
+	url := ts.url(path)
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		ts.t.Fatalf("Failed to dial %s: %v", url, err)
+	}
+
+	welcome := receive(ts.t, conn)
+	if welcome.Action() != message.ActionWelcome || welcome.ClientId() == 0 {
+		ts.t.Fatalf("Want a welcome with a client id; Got %+v", welcome.UnPack())
+	}
+
+	return conn, welcome.ClientId()
+}
+
+func send(t *testing.T, conn *websocket.Conn, payload []byte) {
+	if err := conn.WriteMessage(websocket.BinaryMessage, payload); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+}
+
+func receive(t *testing.T, conn *websocket.Conn) *message.Message {
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, payload, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("Failed to receive message: %v", err)
+	}
+	return message.GetRootAsMessage(payload, 0)
+}
+
+func expectSequences(t *testing.T, conn *websocket.Conn, sequences ...uint64) {
+	for _, want := range sequences {
+		if got := receive(t, conn).Sequence(); got != want {
+			t.Fatalf("Want sequence %d; Got %d", want, got)
+		}
+	}
+}
+
+func TestPlaybackThenLive(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	drawer := ts.dial("/rooms/board")
+	defer drawer.Close()
+
+	send(t, drawer, buildMessage(1, message.ActionDown, 1, 1))
+	send(t, drawer, buildMessage(1, message.ActionMove, 2, 2))
+	send(t, drawer, buildMessage(1, message.ActionUp, 3, 3))
+
+	// Wait for the drawing to be stored before the viewer joins.
+	time.Sleep(100 * time.Millisecond)
+
+	viewer := ts.dial("/rooms/board")
+	defer viewer.Close()
+
+	expectSequences(t, viewer, 1, 2, 3)
+
+	send(t, drawer, buildMessage(1, message.ActionDown, 4, 4))
+	expectSequences(t, viewer, 4)
+
+	// A reconnecting client is sent only what it has not seen.
+	resumed := ts.dial("/rooms/board?since=2")
+	defer resumed.Close()
+
+	expectSequences(t, resumed, 3, 4)
+}
+
+func TestRoomsAreSeparate(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	drawer := ts.dial("/rooms/first")
+	defer drawer.Close()
+
+	other := ts.dial("/rooms/second")
+	defer other.Close()
+
+	send(t, drawer, buildMessage(1, message.ActionDown, 1, 1))
+
+	other.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := other.ReadMessage(); err == nil {
+		t.Fatalf("Message leaked into another room.")
+	}
+}
+
+func TestChunkedPlaybackPrecedesLive(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	drawer := ts.dial("/rooms/board")
+	defer drawer.Close()
+
+	count := playbackChunkSize*2 + 10
+	for idx := 0; idx < count; idx++ {
+		send(t, drawer, buildMessage(1, message.ActionMove, float32(idx), 0))
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	viewer := ts.dial("/rooms/board")
+	defer viewer.Close()
+
+	// Drawn while the viewer's playback is still being written.
+	send(t, drawer, buildMessage(1, message.ActionUp, 0, 0))
+
+	for want := uint64(1); want <= uint64(count+1); want++ {
+		if got := receive(t, viewer).Sequence(); got != want {
+			t.Fatalf("Want sequence %d; Got %d", want, got)
+		}
+	}
+}
+
+func TestClientIdsAssigned(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	drawer, drawerId := ts.join("/rooms/board")
+	defer drawer.Close()
+
+	viewer, viewerId := ts.join("/rooms/board")
+	defer viewer.Close()
+
+	if drawerId == viewerId {
+		t.Fatalf("Both clients were given id %d", drawerId)
+	}
+
+	// Whatever id a client claims, or none at all, its messages carry its own.
+	for _, claimed := range []uint16{viewerId, 999, 0} {
+		send(t, drawer, buildMessage(claimed, message.ActionMove, 1, 1))
+		if got := receive(t, viewer).ClientId(); got != drawerId {
+			t.Errorf("Claimed %d: Want client id %d; Got %d", claimed, drawerId, got)
+		}
+	}
+
+	// Playback shows the same ids as were sent live.
+	late := ts.dial("/rooms/board")
+	defer late.Close()
+
+	for idx := 0; idx < 3; idx++ {
+		if got := receive(t, late).ClientId(); got != drawerId {
+			t.Errorf("Want client id %d in playback; Got %d", drawerId, got)
+		}
+	}
+}
+
+// lockedBuffer collects log lines written from several goroutines.
+type lockedBuffer struct {
+	mutex  sync.Mutex
+	buffer bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
+}
+
+func TestClientLogsCarryId(t *testing.T) {
+	var out lockedBuffer
+	ts := newTestServerWith(t, &Store{Log: logging.New(&out, logging.FormatLogfmt, logging.LevelDebug)})
+	defer ts.Close()
+
+	buggy, id := ts.join("/rooms/board")
+	defer buggy.Close()
+
+	send(t, buggy, []byte{0xff, 0xff, 0xff, 0x7f, 1, 2, 3, 4})
+	buggy.ReadMessage()
+
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "invalid message") {
+			if !strings.Contains(line, fmt.Sprintf("client=%d", id)) {
+				t.Errorf("Want the client's id in its log; Got %s", line)
+			}
+			return
+		}
+	}
+	t.Errorf("Want the invalid message logged; Got %s", out.String())
+}
+
+func TestClientIdsCarryOn(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	drawer, first := ts.join("/rooms/board")
+	send(t, drawer, buildMessage(0, message.ActionDown, 1, 1))
+	expectSequences(t, drawer)
+	time.Sleep(50 * time.Millisecond)
+	drawer.Close()
+
+	// The room is torn down once empty, and its new hub starts afresh.
+	for idx := 0; idx < 50; idx++ {
+		ts.rooms.mutex.Lock()
+		_, open := ts.rooms.rooms["board"]
+		ts.rooms.mutex.Unlock()
+		if !open {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	returning, second := ts.join("/rooms/board")
+	defer returning.Close()
+
+	if second <= first {
+		t.Errorf("Want an id after %d, the last in the log; Got %d", first, second)
+	}
+}
+
+func TestOrigins(t *testing.T) {
+	ts := newTestServerWith(t, &Store{Origins: origins.List{"https://portal.example.com"}})
+	defer ts.Close()
+
+	header := http.Header{"Origin": {"https://portal.example.com"}}
+	conn, _, err := websocket.DefaultDialer.Dial(ts.url("/rooms/board"), header)
+	if err != nil {
+		t.Fatalf("Want the portal allowed; Got %v", err)
+	}
+	conn.Close()
+
+	header.Set("Origin", "https://evil.example.com")
+	_, response, err := websocket.DefaultDialer.Dial(ts.url("/rooms/board"), header)
+	if err == nil || response.StatusCode != http.StatusForbidden {
+		t.Errorf("Want other origins refused")
+	}
+}
@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"backend/internal/health"
+)
+
+// Time allowed for each of a room's goroutines to answer a health check.
+const healthTimeout = time.Second
+
+// liveness checks that the hub and collector of every open room answer.
+func (rs *Rooms) liveness() []health.Check {
+	rs.mutex.Lock()
+	open := make([]*room, 0, len(rs.rooms))
+	for _, r := range rs.rooms {
+		open = append(open, r)
+	}
+	rs.mutex.Unlock()
+
+	sort.Slice(open, func(i, j int) bool { return open[i].id < open[j].id })
+
+	checks := []health.Check{}
+	for _, r := range open {
+		r := r
+		checks = append(checks,
+			health.Check{
+				Name: fmt.Sprintf("room '%s' hub", r.id),
+				Run:  rs.unlessClosed(r, func() error { return r.hub.responsive(healthTimeout) }),
+			},
+			health.Check{
+				Name: fmt.Sprintf("room '%s' collector", r.id),
+				Run:  rs.unlessClosed(r, func() error { return r.collector.Responsive(healthTimeout) }),
+			})
+	}
+
+	return checks
+}
+
+// unlessClosed ignores the failure of a check of a room that was closed while
+// it ran: its goroutines stopped because nobody needs them any more.
+func (rs *Rooms) unlessClosed(r *room, check func() error) func() error {
+	return func() error {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		rs.mutex.Lock()
+		defer rs.mutex.Unlock()
+
+		if rs.rooms[r.id] != r {
+			return nil
+		}
+		return err
+	}
+}
+
+// readiness checks that new rooms can be created in the store.
+func (store *Store) readiness() []health.Check {
+	return []health.Check{health.Writable(store.Directory)}
+}
@@ -0,0 +1,90 @@
+package messaging
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/auth"
+)
+
+func TestHealth(t *testing.T) {
+	ts := newTestServerWith(t, &Store{Auth: &auth.Password{Password: "secret"}})
+	defer ts.Close()
+
+	// Opens the room for the probes to check.
+	r, err := ts.rooms.acquire("probed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.rooms.release(r)
+
+	for _, path := range []string{"/healthz", "/readyz"} {
+		response, err := http.Get(ts.server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			t.Errorf("%s: Want 200 without credentials; Got %d: %s", path, response.StatusCode, body)
+		}
+		if !strings.Contains(string(body), "room 'probed' hub: ok") {
+			t.Errorf("%s: Want the room's hub checked; Got %s", path, body)
+		}
+	}
+
+	response, err := http.Get(ts.server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if response.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Want everything else to need credentials; Got %d", response.StatusCode)
+	}
+}
+
+func TestHubResponsive(t *testing.T) {
+	hub := NewHub("", nil, DefaultExtent, nil)
+
+	// Wedged: nothing runs it.
+	if err := hub.responsive(50 * time.Millisecond); err == nil {
+		t.Errorf("Want an error from a hub that is not running.")
+	}
+
+	hub.Start(context.Background())
+
+	if err := hub.responsive(time.Second); err != nil {
+		t.Errorf("Got an unexpected error from a running hub: %v", err)
+	}
+
+	hub.Close()
+
+	if err := hub.responsive(time.Second); err == nil {
+		t.Errorf("Want an error once the hub stops.")
+	}
+}
+
+func TestClosedRoomsPassLiveness(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	r, err := ts.rooms.acquire("closing")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := ts.rooms.liveness()
+	ts.rooms.release(r)
+
+	for _, check := range checks {
+		if err := check.Run(); err != nil {
+			t.Errorf("%s: Want a room closed meanwhile to pass; Got %v", check.Name, err)
+		}
+	}
+}
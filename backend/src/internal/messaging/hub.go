@@ -2,13 +2,28 @@ package messaging
 
 import (
 	"backend/internal/collector"
-	"log"
+	"backend/internal/logging"
+	"backend/internal/message"
+	"context"
+	"fmt"
+	"sync"
+	"time"
 )
 
+// Hub relays the messages of a room's clients to each other and to the
+// room's collector. A hub runs once, from Start until Close or until the
+// context it was started with is done; to run a room again, create another.
 type Hub struct {
+	// The room the hub serves, as labelled in metrics.
+	room string
+
 	// Registered clients.
 	clients map[*Client]bool
 
+	// Registered clients by the id the hub assigned them.
+	ids    map[uint16]*Client
+	nextId uint16
+
 	inbound chan *InboundPayload
 
 	// Register requests from the clients.
@@ -19,70 +34,347 @@ type Hub struct {
 
 	// Unregister requests from clients.
 	unregister chan *Client
+
+	// Closed by Close to stop the hub.
+	done chan struct{}
+
+	// Closed once run has returned, or by Close if it never started.
+	stopped chan struct{}
+
+	// Guards starting and closing, which may each be asked more than once.
+	lifecycle sync.Mutex
+	started   bool
+	closed    bool
+
+	// Answered by run, to show it is not stuck, see responsive.
+	ping chan chan struct{}
+
+	// Answered by run with the registered clients, see registered.
+	listing chan chan []*Client
+
+	// Largest coordinate accepted from clients, see validate.
+	extent float64
+
+	// Cursors of the registered clients, broadcast every presenceInterval.
+	presence *presence
+
+	// Strokes of the registered clients, for resolving undo and redo.
+	history *strokeHistory
+
+	log *logging.Logger
 }
 
-func newHub(collector *collector.Collector) *Hub {
-	return &Hub{
+// NewHub creates a hub for the room, collecting into collector and refusing
+// coordinates beyond extent. Client ids carry on from the collector's log. A
+// nil collector keeps nothing: messages are relayed, but neither stored nor
+// played back. The hub does nothing until Start.
+func NewHub(room string, collector *collector.Collector, extent float64, log *logging.Logger) *Hub {
+	h := &Hub{
+		room:       room,
 		inbound:    make(chan *InboundPayload),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		clients:    make(map[*Client]bool),
+		ids:        make(map[uint16]*Client),
 		collector:  collector,
+		done:       make(chan struct{}),
+		stopped:    make(chan struct{}),
+		ping:       make(chan chan struct{}),
+		listing:    make(chan chan []*Client),
+		extent:     extent,
+		presence:   newPresence(),
+		history:    newStrokeHistory(),
+		log:        log,
 	}
+
+	// Ids carry on after the last in the log, so that a client id names one
+	// client throughout it and (ClientId, Id) one stroke, even once the
+	// room has been torn down and opened again. Only after 65535 clients do
+	// they wrap around.
+	if collector != nil {
+		h.nextId = collector.LastClientId()
+	}
+
+	return h
+}
+
+// Start runs the hub until Close, or until ctx is done.
+func (h *Hub) Start(ctx context.Context) {
+	h.lifecycle.Lock()
+	defer h.lifecycle.Unlock()
+
+	if h.started || h.closed {
+		return
+	}
+	h.started = true
+
+	go h.run(ctx)
 }
 
-func (h *Hub) run() {
+// Close stops the hub and waits for it, so that a room opened again under
+// the same name starts afresh. Clients still registered are disconnected.
+func (h *Hub) Close() {
+	h.lifecycle.Lock()
+	if !h.closed {
+		h.closed = true
+		close(h.done)
+		if !h.started {
+			close(h.stopped)
+		}
+	}
+	h.lifecycle.Unlock()
+
+	<-h.stopped
+}
+
+// Done is closed once the hub has stopped, whether by Close or by the
+// context it was started with.
+func (h *Hub) Done() <-chan struct{} {
+	return h.stopped
+}
+
+// Broadcast sends a message from the server to every client, stored like
+// any message from a client unless it is a cursor. It is stamped with client
+// id 0, which no client is assigned, so an undo or redo applies to the
+// server's own strokes. Broadcast returns once the hub has taken the message,
+// or with an error if the message is invalid, the hub stops or ctx is done
+// first.
+func (h *Hub) Broadcast(ctx context.Context, payload []byte) error {
+	if _, err := validate(payload, h.extent); err != nil {
+		return err
+	}
+
+	inbound := &InboundPayload{Payload: &payload, Received: time.Now()}
+
+	select {
+	case h.inbound <- inbound:
+		return nil
+	case <-h.stopped:
+		return errHubStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (h *Hub) run(ctx context.Context) {
+	ticker := time.NewTicker(presenceInterval)
+	defer ticker.Stop()
+	defer close(h.stopped)
+
 	for {
 		select {
 
+		case <-h.done:
+			h.unregisterAll()
+			return
+
+		case <-ctx.Done():
+			h.unregisterAll()
+			return
+
 		case client := <-h.register:
+			id, ok := h.assignId()
+			if !ok {
+				client.log.Error("Disconnecting client, no client ids left", "clients", len(h.clients))
+				clientsDisconnected.With(reasonNoIds).Inc()
+				close(client.send)
+				close(client.joined)
+				break
+			}
+			client.id = id
+			client.log = client.log.With("client", id)
+			close(client.joined)
+			h.ids[id] = client
 			h.clients[client] = true
-			messages, err := h.collector.ReadSinceLastClear()
-			if err != nil {
-				log.Printf("Failed to read from log: %v", err)
-			} else {
-				h.sendPlayback(client, messages)
+			clientsConnected.With(h.room).Inc()
+			client.log.Debug("Client joined", "role", client.role)
+			go client.streamPlayback(h.collector, h.lastSequence())
+
+			// Queued behind the playback, like any live message.
+			for _, cursor := range h.presence.snapshot() {
+				h.sendMessage(client, cursor)
 			}
 
 		case client := <-h.unregister:
 			h.unregisterClient(client)
 
+		case pong := <-h.ping:
+			close(pong)
+
+		case reply := <-h.listing:
+			clients := make([]*Client, 0, len(h.clients))
+			for client := range h.clients {
+				clients = append(clients, client)
+			}
+			reply <- clients
+
 		case message := <-h.inbound:
-			h.collector.Sink <- message.Payload
+			if message.Source == nil {
+				h.broadcast(message)
+				break
+			}
+			// Dropped clients may still have messages in flight.
+			if _, ok := h.clients[message.Source]; !ok {
+				break
+			}
+			payload, ok := withClientId(*message.Payload, message.Source.id)
+			if !ok {
+				break
+			}
+			if !message.Source.permits(payload) {
+				messagesDropped.With(reasonNotPermitted).Inc()
+				break
+			}
+			if isCursor(payload) {
+				h.presence.move(message.Source.id, payload)
+				break
+			}
+			payload, ok = h.resolveStroke(message.Source.id, payload)
+			if !ok {
+				messagesDropped.With(reasonNothingToUndo).Inc()
+				break
+			}
+			payload = h.collect(payload, message.Received)
+			// Only the hub knows which stroke an undo or redo took, so the
+			// sender is told as well.
+			undo := isUndo(actionOf(payload))
 			for client := range h.clients {
-				if client != message.Source {
-					h.sendMessage(client, *message.Payload)
+				if client != message.Source || undo {
+					h.sendMessage(client, payload)
+				}
+			}
+
+		case <-ticker.C:
+			for _, update := range h.presence.updates() {
+				for client := range h.clients {
+					if client.id != update.Source {
+						h.sendMessage(client, update.Payload)
+					}
 				}
 			}
 		}
 	}
 }
 
+// registered returns the clients registered with the hub, or none if it has
+// stopped.
+func (h *Hub) registered() []*Client {
+	reply := make(chan []*Client, 1)
+	select {
+	case h.listing <- reply:
+		return <-reply
+	case <-h.stopped:
+		return nil
+	}
+}
+
+// responsive reports whether run answers within timeout.
+func (h *Hub) responsive(timeout time.Duration) error {
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+
+	pong := make(chan struct{})
+	select {
+	case h.ping <- pong:
+	case <-h.stopped:
+		return errHubStopped
+	case <-deadline.C:
+		return fmt.Errorf("hub did not answer within %v", timeout)
+	}
+
+	select {
+	case <-pong:
+		return nil
+	case <-deadline.C:
+		return fmt.Errorf("hub did not answer within %v", timeout)
+	}
+}
+
+// broadcast relays a message of the server's to every client. Cursors go out
+// at once rather than through presence, which tracks clients.
+func (h *Hub) broadcast(message *InboundPayload) {
+	payload, ok := withClientId(*message.Payload, 0)
+	if !ok {
+		return
+	}
+	if !isCursor(payload) {
+		if payload, ok = h.resolveStroke(0, payload); !ok {
+			return
+		}
+		payload = h.collect(payload, message.Received)
+	}
+	for client := range h.clients {
+		h.sendMessage(client, payload)
+	}
+}
+
+// collect stores the payload, returning it with the sequence number it was
+// stored under, or as it is if the hub has no collector.
+func (h *Hub) collect(payload []byte, received time.Time) []byte {
+	if h.collector == nil {
+		return payload
+	}
+	if record := h.collector.Collect(payload, received); record != nil {
+		return withSequence(*record)
+	}
+	return payload
+}
+
+// lastSequence is the sequence number of the last message collected, up to
+// which a joining client is played back.
+func (h *Hub) lastSequence() uint64 {
+	if h.collector == nil {
+		return 0
+	}
+	return h.collector.LastSequence()
+}
+
 func (h *Hub) sendMessage(client *Client, message []byte) {
 	select {
 
 	case client.send <- message:
 
 	default: // If the send channel buffer is full, close the client.
-		log.Printf("Send channel is full, disconnecting client.")
+		client.log.Warn("Disconnecting client with a full send buffer")
+		clientsDisconnected.With(reasonSendBufferFull).Inc()
 		h.unregisterClient(client)
 	}
 }
 
-func (h *Hub) sendPlayback(client *Client, messages [][]byte) {
-	select {
+// resolveStroke keeps the history of the client's strokes up to date, and
+// stamps an undo or redo with the stroke it applies to. It reports false for
+// an undo or redo with nothing to apply to.
+func (h *Hub) resolveStroke(id uint16, payload []byte) ([]byte, bool) {
+	msg := message.GetRootAsMessage(payload, 0)
+
+	action := msg.Action()
+	if !isUndo(action) {
+		h.history.track(id, msg)
+		return payload, true
+	}
 
-	case client.playback <- messages:
+	stroke, ok := h.history.resolve(id, action)
+	if !ok {
+		return nil, false
+	}
+	return withStrokeId(payload, stroke), true
+}
 
-	default: // If the playback channel buffer is full, close the client.
-		log.Printf("Playback channel is full, disconnecting client.")
+// unregisterAll lets every client go as the hub stops.
+func (h *Hub) unregisterAll() {
+	for client := range h.clients {
 		h.unregisterClient(client)
 	}
+	clientsConnected.Delete(h.room)
 }
 
 func (h *Hub) unregisterClient(client *Client) {
 	if _, ok := h.clients[client]; ok {
 		delete(h.clients, client)
+		delete(h.ids, client.id)
+		h.presence.leave(client.id)
+		h.history.leave(client.id)
+		clientsConnected.With(h.room).Dec()
 		close(client.send)
 	}
 }
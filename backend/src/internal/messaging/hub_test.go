@@ -0,0 +1,144 @@
+package messaging
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"backend/internal/message"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestHubLifecycle(t *testing.T) {
+	unstarted := NewHub("", nil, DefaultExtent, nil)
+	unstarted.Close()
+	unstarted.Close()
+
+	select {
+	case <-unstarted.Done():
+	default:
+		t.Errorf("Want a hub closed before starting to be done.")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hub := NewHub("", nil, DefaultExtent, nil)
+	hub.Start(ctx)
+	hub.Start(ctx)
+
+	if err := hub.responsive(time.Second); err != nil {
+		t.Fatalf("Got an unexpected error from a running hub: %v", err)
+	}
+
+	cancel()
+
+	select {
+	case <-hub.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Want the hub to stop once its context is done.")
+	}
+
+	hub.Close()
+
+	if err := hub.Broadcast(context.Background(), buildClear()); err != errHubStopped {
+		t.Errorf("Want %v broadcasting to a stopped hub; Got %v", errHubStopped, err)
+	}
+}
+
+func TestHubWithoutCollector(t *testing.T) {
+	hub := NewHub("", nil, DefaultExtent, nil)
+	hub.Start(context.Background())
+	defer hub.Close()
+
+	client := &Client{
+		hub:      hub,
+		send:     make(chan []byte, 4),
+		playback: make(chan [][]byte, 1),
+		quit:     make(chan struct{}),
+		joined:   make(chan struct{})}
+	hub.register <- client
+	<-client.joined
+
+	// Welcomed, with nothing to play back.
+	chunk := <-client.playback
+	if msg := message.GetRootAsMessage(chunk[0], 0); len(chunk) != 1 || msg.Action() != message.ActionWelcome {
+		t.Errorf("Want only a welcome; Got %d messages", len(chunk))
+	}
+	if _, ok := <-client.playback; ok {
+		t.Errorf("Want playback over after the welcome.")
+	}
+
+	if err := hub.Broadcast(context.Background(), buildMessage(7, message.ActionDown, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-client.send:
+		msg := message.GetRootAsMessage(payload, 0)
+		if msg.Action() != message.ActionDown || msg.Sequence() != 0 {
+			t.Errorf("Want the stroke relayed unstored; Got %+v", msg.UnPack())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Want the broadcast relayed.")
+	}
+
+	if err := hub.responsive(time.Second); err != nil {
+		t.Errorf("Want the hub running; Got %v", err)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	conn := ts.dial("/")
+	defer conn.Close()
+
+	r, err := ts.rooms.acquire(defaultRoom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.rooms.release(r)
+
+	if err := r.hub.Broadcast(context.Background(), []byte("not a message")); err == nil {
+		t.Errorf("Want an invalid message refused.")
+	}
+
+	if err := r.hub.Broadcast(context.Background(), buildMessage(7, message.ActionDown, 1, 2)); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := receive(t, conn)
+	if msg.Action() != message.ActionDown || msg.ClientId() != 0 || msg.Sequence() == 0 {
+		t.Errorf("Want a stored stroke from client 0; Got %+v", msg.UnPack())
+	}
+
+	// Stored like any stroke, so played back to those joining later.
+	late := ts.dial("/")
+	defer late.Close()
+
+	if msg := receive(t, late); msg.Action() != message.ActionDown || msg.ClientId() != 0 {
+		t.Errorf("Want the broadcast in playback; Got %+v", msg.UnPack())
+	}
+}
+
+func TestCloseDisconnectsClients(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	conn := ts.dial("/rooms/closing")
+	defer conn.Close()
+
+	r, err := ts.rooms.acquire("closing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ts.rooms.release(r)
+
+	r.hub.Close()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseNoStatusReceived) {
+		t.Errorf("Want the client disconnected once the hub closes; Got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package messaging
+
+import (
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// assignId picks the next client id not in use in the hub. Id 0 is never
+// assigned, it is what a message without a client id decodes to.
+func (h *Hub) assignId() (uint16, bool) {
+	for attempts := 0; attempts < 1<<16; attempts++ {
+		h.nextId = h.nextId + 1
+		if h.nextId == 0 {
+			continue
+		}
+
+		if _, taken := h.ids[h.nextId]; !taken {
+			return h.nextId, true
+		}
+	}
+
+	return 0, false
+}
+
+// announce builds a message from the hub about a client: ActionWelcome tells
+// a newly registered client the id the hub gave it, ActionLeave tells the
+// others that it has gone.
+func announce(id uint16, action message.Action) []byte {
+	msg := message.MessageT{ClientId: id, Action: action}
+
+	builder := flatbuffers.NewBuilder(32)
+	builder.Finish(msg.Pack(builder))
+
+	return builder.FinishedBytes()
+}
+
+// withClientId returns the payload with its client id replaced by the one the
+// hub assigned to the sender, whatever the sender put there. It reports false
+// for payloads that cannot be decoded.
+func withClientId(payload []byte, id uint16) (result []byte, ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			result, ok = nil, false
+		}
+	}()
+
+	msg := message.GetRootAsMessage(payload, 0)
+	if msg.ClientId() == id {
+		return payload, true
+	}
+
+	// Rewrite in place if the field is present, otherwise rebuild.
+	if msg.MutateClientId(id) {
+		return payload, true
+	}
+
+	rebuilt := msg.UnPack()
+	rebuilt.ClientId = id
+
+	builder := flatbuffers.NewBuilder(len(payload) + 8)
+	builder.Finish(rebuilt.Pack(builder))
+
+	return builder.FinishedBytes(), true
+}
+
+// actionOf returns the action of a payload the hub has accepted. validate has
+// already checked that it decodes.
+func actionOf(payload []byte) message.Action {
+	return message.GetRootAsMessage(payload, 0).Action()
+}
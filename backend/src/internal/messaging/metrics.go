@@ -0,0 +1,52 @@
+package messaging
+
+import (
+	"backend/internal/message"
+	"backend/internal/metrics"
+)
+
+// Message sizes from 32 bytes to 32KiB, a little over DefaultConnection's
+// MaxMessageSize.
+var sizeBuckets = metrics.ExponentialBuckets(32, 2, 11)
+
+var (
+	clientsConnected = metrics.Default.NewGauge("doodleopia_clients",
+		"Clients connected, by room. The default board is room \"\".", "room")
+
+	clientsDisconnected = metrics.Default.NewCounter("doodleopia_clients_disconnected_total",
+		"Clients disconnected by the server, by reason.", "reason")
+
+	messagesInbound = metrics.Default.NewCounter("doodleopia_messages_inbound_total",
+		"Valid messages received from clients, by action.", "action")
+
+	messagesOutbound = metrics.Default.NewCounter("doodleopia_messages_outbound_total",
+		"Messages written to clients, playback included, by action.", "action")
+
+	messagesDropped = metrics.Default.NewCounter("doodleopia_messages_dropped_total",
+		"Messages from clients that were not passed on, by reason.", "reason")
+
+	messageSizes = metrics.Default.NewHistogram("doodleopia_message_size_bytes",
+		"Sizes of messages, by direction: in from clients or out to them.", sizeBuckets, "direction")
+)
+
+// Reasons for clientsDisconnected and messagesDropped.
+const (
+	reasonSendBufferFull  = "send_buffer_full"
+	reasonPlaybackBacklog = "playback_backlog"
+	reasonInvalidMessage  = "invalid_message"
+	reasonRateLimited     = "rate_limited"
+	reasonNoIds           = "no_ids"
+	reasonCoalesced       = "coalesced"
+	reasonNotPermitted    = "not_permitted"
+	reasonNothingToUndo   = "nothing_to_undo"
+)
+
+func countInbound(action message.Action, payload []byte) {
+	messagesInbound.With(action.String()).Inc()
+	messageSizes.With("in").Observe(float64(len(payload)))
+}
+
+func countOutbound(payload []byte) {
+	messagesOutbound.With(actionOf(payload).String()).Inc()
+	messageSizes.With("out").Observe(float64(len(payload)))
+}
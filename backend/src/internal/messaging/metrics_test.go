@@ -0,0 +1,66 @@
+package messaging
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/internal/message"
+)
+
+func TestMetrics(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	downs := messagesInbound.With("Down")
+	inboundBefore := downs.Value()
+	outboundBefore := messagesOutbound.With("Down").Value()
+
+	drawer := ts.dial("/rooms/counted")
+	defer drawer.Close()
+
+	viewer := ts.dial("/rooms/counted")
+
+	if got := clientsConnected.With("counted").Value(); got != 2 {
+		t.Errorf("Want 2 clients connected; Got %v", got)
+	}
+
+	send(t, drawer, buildMessage(1, message.ActionDown, 1, 1))
+	receive(t, viewer)
+
+	if got := downs.Value() - inboundBefore; got != 1 {
+		t.Errorf("Want 1 inbound Down; Got %v", got)
+	}
+	if got := messagesOutbound.With("Down").Value() - outboundBefore; got != 1 {
+		t.Errorf("Want 1 outbound Down; Got %v", got)
+	}
+
+	viewer.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for clientsConnected.With("counted").Value() != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := clientsConnected.With("counted").Value(); got != 1 {
+		t.Errorf("Want 1 client connected after one left; Got %v", got)
+	}
+
+	response, err := http.Get(ts.server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+
+	body, _ := ioutil.ReadAll(response.Body)
+	for _, want := range []string{
+		`doodleopia_clients{room="counted"} 1`,
+		`doodleopia_messages_inbound_total{action="Down"}`,
+		`doodleopia_message_size_bytes_bucket{direction="in",le="+Inf"}`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("Want %s in\n%s", want, body)
+		}
+	}
+}
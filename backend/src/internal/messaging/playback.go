@@ -0,0 +1,121 @@
+package messaging
+
+import (
+	"backend/internal/collector"
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Number of messages written to the websocket per playback chunk.
+const playbackChunkSize = 256
+
+// streamPlayback queues a welcome and then the room's history for the client,
+// in chunks. Records after upto are left out: the hub sends those live.
+func (c *Client) streamPlayback(source *collector.Collector, upto uint64) {
+	defer close(c.playback)
+
+	// Playback opens with the client's id, ahead of any message.
+	select {
+	case c.playback <- [][]byte{announce(c.id, message.ActionWelcome)}:
+	case <-c.quit:
+		return
+	}
+
+	// A hub without a collector has no history to play back.
+	if source == nil {
+		return
+	}
+
+	// Everything up to upto has been collected, but may not be written yet.
+	source.Flush()
+
+	records, err := source.ReadSinceLastClear()
+	if err != nil {
+		c.log.Error("Failed to read history for playback", "err", err)
+		return
+	}
+
+	for idx := range records {
+		if records[idx].Sequence > upto {
+			records = records[:idx]
+			break
+		}
+	}
+
+	records = withoutRemoved(records, resumeFrom(records, c.since))
+
+	var messages [][]byte
+	if c.coalesce {
+		messages = coalesce(records)
+	} else {
+		messages = make([][]byte, len(records))
+		for idx := range records {
+			messages[idx] = withSequence(records[idx])
+		}
+	}
+
+	for start := 0; start < len(messages); start = start + playbackChunkSize {
+		end := start + playbackChunkSize
+		if end > len(messages) {
+			end = len(messages)
+		}
+
+		select {
+		case c.playback <- messages[start:end]:
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// withSequence returns the stored message with its sequence number filled
+// in. Clients keep the highest sequence number they have received and hand it
+// back when reconnecting, see resumeFrom.
+func withSequence(record collector.Record) (payload []byte) {
+	if record.Sequence == 0 {
+		return record.Data
+	}
+
+	defer func() {
+		// Leave anything that fails to decode as it was stored.
+		if err := recover(); err != nil {
+			payload = record.Data
+		}
+	}()
+
+	msg := message.GetRootAsMessage(record.Data, 0).UnPack()
+	msg.Sequence = record.Sequence
+
+	builder := flatbuffers.NewBuilder(len(record.Data) + 16)
+	builder.Finish(msg.Pack(builder))
+
+	return builder.FinishedBytes()
+}
+
+// resumeFrom selects the playback for a client that has already seen every
+// record up to and including since. records is the history since the last
+// clear. If the client's state predates that clear, or cannot be placed in
+// the history at all, it gets the full playback.
+func resumeFrom(records []collector.Record, since uint64) []collector.Record {
+	if since == 0 || len(records) == 0 {
+		return records
+	}
+
+	first := records[0].Sequence
+	last := records[len(records)-1].Sequence
+
+	// Unsequenced records from an old log, a client that missed the last
+	// clear, or a client that knows of records this log never had.
+	if first == 0 || since < first || since > last {
+		return records
+	}
+
+	for idx := range records {
+		if records[idx].Sequence > since {
+			return records[idx:]
+		}
+	}
+
+	return records[len(records):]
+}
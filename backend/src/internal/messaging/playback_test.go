@@ -0,0 +1,88 @@
+package messaging
+
+import (
+	"testing"
+
+	"backend/internal/collector"
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+func buildMessage(clientId uint16, action message.Action, x, y float32) []byte {
+	builder := flatbuffers.NewBuilder(80)
+	message.MessageStart(builder)
+	message.MessageAddClientId(builder, clientId)
+	message.MessageAddAction(builder, action)
+	message.MessageAddData(builder, message.CreateCoordinate(builder, x, y))
+	message.MessageAddId(builder, 0)
+	msg := message.MessageEnd(builder)
+	builder.Finish(msg)
+	return builder.FinishedBytes()
+}
+
+func sequenced(sequences ...uint64) []collector.Record {
+	records := []collector.Record{}
+	for _, sequence := range sequences {
+		records = append(records, collector.Record{Sequence: sequence})
+	}
+	return records
+}
+
+func sequencesOf(records []collector.Record) []uint64 {
+	result := []uint64{}
+	for _, record := range records {
+		result = append(result, record.Sequence)
+	}
+	return result
+}
+
+func TestResumeFrom(t *testing.T) {
+	tests := []struct {
+		name    string
+		records []collector.Record
+		since   uint64
+		want    []uint64
+	}{
+		{"fresh client", sequenced(4, 5, 6), 0, []uint64{4, 5, 6}},
+		{"resume mid history", sequenced(4, 5, 6), 4, []uint64{5, 6}},
+		{"resume with gap", sequenced(4, 7, 9), 5, []uint64{7, 9}},
+		{"up to date", sequenced(4, 5, 6), 6, []uint64{}},
+		{"before last clear", sequenced(4, 5, 6), 3, []uint64{4, 5, 6}},
+		{"unknown to this log", sequenced(4, 5, 6), 10, []uint64{4, 5, 6}},
+		{"legacy log", sequenced(0, 0, 0), 2, []uint64{0, 0, 0}},
+	}
+
+	for _, test := range tests {
+		got := sequencesOf(resumeFrom(test.records, test.since))
+		if len(got) != len(test.want) {
+			t.Errorf("%s: Want %v; Got %v", test.name, test.want, got)
+			continue
+		}
+
+		for idx := range got {
+			if got[idx] != test.want[idx] {
+				t.Errorf("%s: Want %v; Got %v", test.name, test.want, got)
+				break
+			}
+		}
+	}
+}
+
+func TestWithSequence(t *testing.T) {
+	payload := buildMessage(12345, message.ActionMove, 10.5, 20.5)
+
+	stamped := message.GetRootAsMessage(withSequence(collector.Record{Sequence: 42, Data: payload}), 0)
+
+	if stamped.Sequence() != 42 {
+		t.Errorf("Want sequence 42; Got %d", stamped.Sequence())
+	}
+
+	if stamped.ClientId() != 12345 || stamped.Action() != message.ActionMove {
+		t.Errorf("Stamped message lost its fields: %+v", stamped.UnPack())
+	}
+
+	if x := stamped.Data(nil).X(); x != 10.5 {
+		t.Errorf("Want x=10.5; Got %f", x)
+	}
+}
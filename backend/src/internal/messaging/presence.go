@@ -0,0 +1,76 @@
+package messaging
+
+import (
+	"time"
+
+	"backend/internal/message"
+)
+
+// How often the hub sends out cursor movements and departures.
+const presenceInterval = 50 * time.Millisecond
+
+// presence keeps the latest cursor of each client in a hub. Cursors are not
+// stored, and only the latest position of each is broadcast per interval.
+type presence struct {
+	// The latest cursor message of each client, by client id.
+	cursors map[uint16][]byte
+
+	// Clients whose cursor moved, and clients that left, since the last
+	// interval.
+	moved map[uint16]bool
+	left  []uint16
+}
+
+// An update to send to every client but Source.
+type presenceUpdate struct {
+	Source  uint16
+	Payload []byte
+}
+
+func newPresence() *presence {
+	return &presence{
+		cursors: make(map[uint16][]byte),
+		moved:   make(map[uint16]bool),
+	}
+}
+
+func (p *presence) move(id uint16, payload []byte) {
+	p.cursors[id] = payload
+	p.moved[id] = true
+}
+
+func (p *presence) leave(id uint16) {
+	delete(p.cursors, id)
+	delete(p.moved, id)
+	p.left = append(p.left, id)
+}
+
+// snapshot is every cursor currently known, for a newly registered client.
+func (p *presence) snapshot() [][]byte {
+	payloads := [][]byte{}
+	for _, payload := range p.cursors {
+		payloads = append(payloads, payload)
+	}
+	return payloads
+}
+
+// updates returns what happened since the last call.
+func (p *presence) updates() []presenceUpdate {
+	updates := []presenceUpdate{}
+
+	for id := range p.moved {
+		updates = append(updates, presenceUpdate{Source: id, Payload: p.cursors[id]})
+		delete(p.moved, id)
+	}
+
+	for _, id := range p.left {
+		updates = append(updates, presenceUpdate{Source: id, Payload: announce(id, message.ActionLeave)})
+	}
+	p.left = nil
+
+	return updates
+}
+
+func isCursor(payload []byte) bool {
+	return actionOf(payload) == message.ActionCursor
+}
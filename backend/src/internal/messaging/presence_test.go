@@ -0,0 +1,83 @@
+package messaging
+
+import (
+	"testing"
+	"time"
+
+	"backend/internal/message"
+)
+
+func TestPresenceCoalesces(t *testing.T) {
+	p := newPresence()
+
+	p.move(1, buildMessage(1, message.ActionCursor, 1, 1))
+	p.move(1, buildMessage(1, message.ActionCursor, 2, 2))
+	p.move(2, buildMessage(2, message.ActionCursor, 5, 5))
+
+	updates := p.updates()
+	if len(updates) != 2 {
+		t.Fatalf("Want one update per client; Got %d", len(updates))
+	}
+	for _, update := range updates {
+		msg := message.GetRootAsMessage(update.Payload, 0)
+		if update.Source == 1 && msg.Data(nil).X() != 2 {
+			t.Errorf("Want the latest cursor of client 1; Got x=%f", msg.Data(nil).X())
+		}
+	}
+
+	if updates := p.updates(); len(updates) != 0 {
+		t.Errorf("Want nothing new; Got %d updates", len(updates))
+	}
+
+	p.leave(1)
+	updates = p.updates()
+	if len(updates) != 1 || message.GetRootAsMessage(updates[0].Payload, 0).Action() != message.ActionLeave {
+		t.Fatalf("Want a single leave; Got %d updates", len(updates))
+	}
+
+	if snapshot := p.snapshot(); len(snapshot) != 1 {
+		t.Errorf("Want only client 2 in the snapshot; Got %d cursors", len(snapshot))
+	}
+}
+
+func TestPresence(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	pointer, pointerId := ts.join("/rooms/board")
+	defer pointer.Close()
+
+	viewer := ts.dial("/rooms/board")
+	defer viewer.Close()
+
+	for idx := 1; idx <= 10; idx++ {
+		send(t, pointer, buildMessage(0, message.ActionCursor, float32(idx), 0))
+	}
+
+	// Moves within an interval arrive as one, with the latest position.
+	time.Sleep(2 * presenceInterval)
+	for {
+		msg := receive(t, viewer)
+		if msg.Action() != message.ActionCursor || msg.ClientId() != pointerId {
+			t.Fatalf("Want the pointer's cursor; Got %+v", msg.UnPack())
+		}
+		if msg.Data(nil).X() == 10 {
+			break
+		}
+	}
+
+	// Newcomers are told where everyone's cursor is.
+	late := ts.dial("/rooms/board")
+	defer late.Close()
+
+	if msg := receive(t, late); msg.Action() != message.ActionCursor || msg.Data(nil).X() != 10 {
+		t.Fatalf("Want the pointer's cursor in the snapshot; Got %+v", msg.UnPack())
+	}
+
+	// And when someone leaves.
+	pointer.Close()
+
+	if msg := receive(t, viewer); msg.Action() != message.ActionLeave || msg.ClientId() != pointerId {
+		t.Fatalf("Want the pointer to leave; Got %+v", msg.UnPack())
+	}
+}
@@ -0,0 +1,299 @@
+package messaging
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"backend/internal/message"
+)
+
+// What happens to a message that is over its client's rate limit.
+type Policy int
+
+const (
+	// The message is discarded.
+	PolicyDrop Policy = iota
+
+	// The message is held back until the limit allows it, replacing any
+	// message of the same action already held. Suits cursors and moves,
+	// where only the latest position matters.
+	PolicyCoalesce
+
+	// The client is disconnected.
+	PolicyDisconnect
+)
+
+var policyNames = map[Policy]string{
+	PolicyDrop:       "drop",
+	PolicyCoalesce:   "coalesce",
+	PolicyDisconnect: "disconnect",
+}
+
+func (p Policy) String() string {
+	if name, ok := policyNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+func ParsePolicy(name string) (Policy, error) {
+	for policy, policyName := range policyNames {
+		if name == policyName {
+			return policy, nil
+		}
+	}
+	return PolicyDrop, fmt.Errorf("Unknown rate limit policy '%s'", name)
+}
+
+// A token bucket allowing Rate messages per second on average, in bursts of
+// up to Burst. A zero Rate is no limit at all.
+type RateLimit struct {
+	Rate   float64
+	Burst  int
+	Policy Policy
+}
+
+// The limits of a client. Every action gets a bucket of its own; cursors,
+// which clients send far more often than anything else, are limited apart.
+type Limits struct {
+	Actions RateLimit
+	Cursor  RateLimit
+}
+
+// Generous for anyone drawing by hand, far too slow for a bot.
+var DefaultLimits = Limits{
+	Actions: RateLimit{Rate: 200, Burst: 400, Policy: PolicyDisconnect},
+	Cursor:  RateLimit{Rate: 30, Burst: 60, Policy: PolicyCoalesce},
+}
+
+func (l Limits) For(action message.Action) RateLimit {
+	if action == message.ActionCursor {
+		return l.Cursor
+	}
+	return l.Actions
+}
+
+type bucket struct {
+	limit  RateLimit
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(limit RateLimit, now time.Time) *bucket {
+	if limit.Burst < 1 {
+		limit.Burst = 1
+	}
+	return &bucket{limit: limit, tokens: float64(limit.Burst), last: now}
+}
+
+func (b *bucket) refill(now time.Time) {
+	if now.After(b.last) {
+		refill := now.Sub(b.last).Seconds() * b.limit.Rate
+		b.tokens = math.Min(float64(b.limit.Burst), b.tokens+refill)
+		b.last = now
+	}
+}
+
+// take spends a token if there is one, and otherwise says how long until
+// there will be.
+func (b *bucket) take(now time.Time) (bool, time.Duration) {
+	if b.limit.Rate <= 0 {
+		return true, 0
+	}
+
+	b.refill(now)
+
+	if b.tokens >= 1 {
+		b.tokens = b.tokens - 1
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / b.limit.Rate * float64(time.Second))
+}
+
+// spend takes a token whether or not there is one, running the bucket into
+// debt that later messages wait out.
+func (b *bucket) spend(now time.Time) {
+	if b.limit.Rate <= 0 {
+		return
+	}
+
+	b.refill(now)
+	b.tokens = b.tokens - 1
+}
+
+var (
+	// The client went over a limit with PolicyDisconnect.
+	errRateLimited = errors.New("rate limit exceeded")
+
+	// The hub is gone, nothing more can be forwarded.
+	errHubStopped = errors.New("hub stopped")
+)
+
+// limiter sits between a client's readPump and its hub.
+type limiter struct {
+	limits Limits
+
+	// Hands a message to the hub, returning false if the hub has stopped.
+	forward func(*InboundPayload) bool
+
+	mutex   sync.Mutex
+	buckets map[message.Action]*bucket
+
+	// Messages held back by PolicyCoalesce, at most one per action, in the
+	// order their actions were first held, and the timer that releases the
+	// first of them due.
+	held   map[message.Action]*InboundPayload
+	order  []message.Action
+	timer  *time.Timer
+	due    time.Time
+	closed bool
+}
+
+func newLimiter(limits Limits, forward func(*InboundPayload) bool) *limiter {
+	return &limiter{
+		limits:  limits,
+		forward: forward,
+		buckets: make(map[message.Action]*bucket),
+		held:    make(map[message.Action]*InboundPayload),
+	}
+}
+
+// submit forwards the payload if the client's limits allow it and applies
+// the limit's policy if not.
+func (l *limiter) submit(action message.Action, payload *InboundPayload) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	b, ok := l.buckets[action]
+	if !ok {
+		b = newBucket(l.limits.For(action), payload.Received)
+		l.buckets[action] = b
+	}
+
+	allowed, wait := b.take(payload.Received)
+	if allowed {
+		// Anything held of the same stroke was received first, so it goes
+		// first.
+		if !l.flushBefore(action, payload.Received) || !l.forward(payload) {
+			return errHubStopped
+		}
+		return nil
+	}
+
+	switch b.limit.Policy {
+	case PolicyDisconnect:
+		return errRateLimited
+
+	case PolicyCoalesce:
+		if _, holding := l.held[action]; holding {
+			messagesDropped.With(reasonCoalesced).Inc()
+		} else {
+			l.order = append(l.order, action)
+			l.schedule(wait)
+		}
+		l.held[action] = payload
+
+	case PolicyDrop:
+		messagesDropped.With(reasonRateLimited).Inc()
+	}
+
+	return nil
+}
+
+// schedule arms the timer to release held messages after wait, unless it
+// will fire sooner already.
+func (l *limiter) schedule(wait time.Duration) {
+	due := time.Now().Add(wait)
+	if l.timer != nil && !l.due.After(due) {
+		return
+	}
+
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+	l.due = due
+	l.timer = time.AfterFunc(wait, l.release)
+}
+
+// release sends on the held messages whose buckets have refilled, each
+// taking a token like any other message, and waits again for the rest.
+func (l *limiter) release() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.timer = nil
+	if l.closed {
+		return
+	}
+
+	now := time.Now()
+	order := l.order
+	l.order = nil
+
+	for idx, action := range order {
+		allowed, wait := l.buckets[action].take(now)
+		if !allowed {
+			l.order = append(l.order, action)
+			l.schedule(wait)
+			continue
+		}
+
+		payload := l.held[action]
+		delete(l.held, action)
+		if !l.forward(payload) {
+			l.order = append(l.order, order[idx+1:]...)
+			return
+		}
+	}
+}
+
+// flushBefore sends on the held messages that must precede a message of the
+// given action. Strokes must arrive in order, so a held move goes ahead of
+// the up that follows it, spending a token it may not have; cursors stand
+// apart, and wait for their own tokens.
+func (l *limiter) flushBefore(action message.Action, now time.Time) bool {
+	order := l.order
+	l.order = nil
+
+	for idx, held := range order {
+		if (held == message.ActionCursor) != (action == message.ActionCursor) {
+			l.order = append(l.order, held)
+			continue
+		}
+
+		l.buckets[held].spend(now)
+
+		payload := l.held[held]
+		delete(l.held, held)
+		if !l.forward(payload) {
+			l.order = append(l.order, order[idx+1:]...)
+			return false
+		}
+	}
+
+	return true
+}
+
+// close sends on anything still held once the client has gone: it was
+// received, and may be the last of a stroke.
+func (l *limiter) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for _, action := range l.order {
+		if !l.forward(l.held[action]) {
+			break
+		}
+	}
+	l.order = nil
+	l.held = make(map[message.Action]*InboundPayload)
+
+	l.closed = true
+	if l.timer != nil {
+		l.timer.Stop()
+	}
+}
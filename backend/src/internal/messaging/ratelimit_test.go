@@ -0,0 +1,133 @@
+package messaging
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"backend/internal/message"
+)
+
+func TestBucket(t *testing.T) {
+	start := time.Now()
+	b := newBucket(RateLimit{Rate: 10, Burst: 3}, start)
+
+	for idx := 0; idx < 3; idx++ {
+		if ok, _ := b.take(start); !ok {
+			t.Fatalf("Want the burst of 3 allowed; Refused message %d", idx+1)
+		}
+	}
+
+	ok, wait := b.take(start)
+	if ok || wait != 100*time.Millisecond {
+		t.Fatalf("Want a refusal and a 100ms wait; Got %v, %v", ok, wait)
+	}
+
+	if ok, _ := b.take(start.Add(100 * time.Millisecond)); !ok {
+		t.Errorf("Want a token after 100ms")
+	}
+
+	unlimited := newBucket(RateLimit{}, start)
+	for idx := 0; idx < 1000; idx++ {
+		if ok, _ := unlimited.take(start); !ok {
+			t.Fatalf("Want no limit with a zero rate")
+		}
+	}
+}
+
+type forwarded struct {
+	mutex    sync.Mutex
+	payloads []*InboundPayload
+}
+
+func (f *forwarded) forward(payload *InboundPayload) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.payloads = append(f.payloads, payload)
+	return true
+}
+
+func (f *forwarded) count() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return len(f.payloads)
+}
+
+func TestLimiterPolicies(t *testing.T) {
+	limit := func(policy Policy) Limits {
+		return Limits{
+			Actions: RateLimit{Rate: 20, Burst: 2, Policy: policy},
+			Cursor:  RateLimit{Rate: 20, Burst: 1, Policy: policy},
+		}
+	}
+
+	now := time.Now()
+	inbound := func() *InboundPayload { return &InboundPayload{Received: now} }
+
+	// Dropped messages are gone, and each action has a bucket of its own.
+	dropped := &forwarded{}
+	l := newLimiter(limit(PolicyDrop), dropped.forward)
+	for idx := 0; idx < 5; idx++ {
+		l.submit(message.ActionMove, inbound())
+	}
+	l.submit(message.ActionUp, inbound())
+	l.submit(message.ActionCursor, inbound())
+	l.submit(message.ActionCursor, inbound())
+	l.close()
+
+	if got := dropped.count(); got != 4 {
+		t.Errorf("Drop: Want 2 moves, an up and a cursor forwarded; Got %d", got)
+	}
+
+	// Going over the limit disconnects.
+	l = newLimiter(limit(PolicyDisconnect), (&forwarded{}).forward)
+	l.submit(message.ActionMove, inbound())
+	l.submit(message.ActionMove, inbound())
+	if err := l.submit(message.ActionMove, inbound()); err != errRateLimited {
+		t.Errorf("Disconnect: Want errRateLimited; Got %v", err)
+	}
+	l.close()
+
+	// Only the latest held message is sent on, once the bucket refills.
+	coalesced := &forwarded{}
+	l = newLimiter(limit(PolicyCoalesce), coalesced.forward)
+	defer l.close()
+
+	l.submit(message.ActionCursor, inbound())
+	l.submit(message.ActionCursor, inbound())
+	latest := inbound()
+	l.submit(message.ActionCursor, latest)
+
+	if got := coalesced.count(); got != 1 {
+		t.Fatalf("Coalesce: Want 1 cursor forwarded before the refill; Got %d", got)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+
+	coalesced.mutex.Lock()
+	defer coalesced.mutex.Unlock()
+	if len(coalesced.payloads) != 2 || coalesced.payloads[1] != latest {
+		t.Errorf("Coalesce: Want the latest cursor forwarded after the refill; Got %d", len(coalesced.payloads))
+	}
+}
+
+func TestLimiterCoalesceKeepsRate(t *testing.T) {
+	const rate, burst = 50, 5
+	limits := Limits{Cursor: RateLimit{Rate: rate, Burst: burst, Policy: PolicyCoalesce}}
+
+	coalesced := &forwarded{}
+	l := newLimiter(limits, coalesced.forward)
+	defer l.close()
+
+	// Flooding, so that something is always held when the bucket refills.
+	started := time.Now()
+	for time.Since(started) < 500*time.Millisecond {
+		l.submit(message.ActionCursor, &InboundPayload{Received: time.Now()})
+		time.Sleep(time.Millisecond)
+	}
+
+	window := time.Since(started).Seconds()
+	if got, most := coalesced.count(), int(rate*window)+burst; got > most {
+		t.Errorf("Want at most %d cursors forwarded in %.2fs; Got %d", most, window, got)
+	}
+}
@@ -0,0 +1,105 @@
+package messaging
+
+import (
+	"fmt"
+	"net/http"
+
+	"backend/internal/auth"
+	"backend/internal/message"
+)
+
+// What a client may do on a board. A client's role is settled when it
+// connects, from the claims of the token it presents.
+type Role int
+
+const (
+	// Sees the board, but everything it sends is refused: its cursor too,
+	// deliberately, so that an audience watching a projected board does
+	// not crowd it with cursors.
+	RoleViewer Role = iota + 1
+
+	// Draws, but cannot clear the board or erase the strokes of others.
+	RoleEditor
+
+	// Draws, clears and erases.
+	RoleOwner
+)
+
+var roleNames = map[Role]string{
+	RoleViewer: "viewer",
+	RoleEditor: "editor",
+	RoleOwner:  "owner",
+}
+
+func (r Role) String() string {
+	if name, ok := roleNames[r]; ok {
+		return name
+	}
+	return fmt.Sprintf("Role(%d)", int(r))
+}
+
+func ParseRole(name string) (Role, error) {
+	for role, roleName := range roleNames {
+		if name == roleName {
+			return role, nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown role '%s'", name)
+}
+
+// permits reports whether a client in this role may send the action. Viewers
+// may send nothing at all, cursors included.
+func (r Role) permits(action message.Action) bool {
+	switch r {
+	case RoleOwner:
+		return true
+	case RoleEditor:
+		return action != message.ActionClear
+	default:
+		return false
+	}
+}
+
+// mayErase reports whether a client in this role may erase strokes drawn by
+// target: owners anyone's, editors only their own.
+func (r Role) mayErase(id, target uint16) bool {
+	switch r {
+	case RoleOwner:
+		return true
+	case RoleEditor:
+		return id == target
+	default:
+		return false
+	}
+}
+
+// permits reports whether the client may send the payload, which carries the
+// client's id.
+func (c *Client) permits(payload []byte) bool {
+	msg := message.GetRootAsMessage(payload, 0)
+
+	action := msg.Action()
+	if action == message.ActionErase && !c.role.mayErase(c.id, msg.Target()) {
+		return false
+	}
+	return c.role.permits(action)
+}
+
+// roleFor returns the role granted to the request in the given room, or false
+// if its claims do not open the room at all.
+func (s *Store) roleFor(r *http.Request, room string) (Role, bool) {
+	claims := auth.ClaimsFrom(r.Context())
+	if claims != nil && !claims.AllowsRoom(room) {
+		return 0, false
+	}
+
+	if claims == nil || claims.Role == "" {
+		if s.DefaultRole == 0 {
+			return RoleOwner, true
+		}
+		return s.DefaultRole, true
+	}
+
+	role, err := ParseRole(claims.Role)
+	return role, err == nil
+}
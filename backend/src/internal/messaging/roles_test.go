@@ -0,0 +1,107 @@
+package messaging
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/message"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRolePermits(t *testing.T) {
+	tests := []struct {
+		role   Role
+		action message.Action
+		want   bool
+	}{
+		{RoleOwner, message.ActionClear, true},
+		{RoleOwner, message.ActionMove, true},
+		{RoleEditor, message.ActionMove, true},
+		{RoleEditor, message.ActionClear, false},
+		{RoleViewer, message.ActionMove, false},
+		// Hidden on purpose, so an audience does not crowd the board.
+		{RoleViewer, message.ActionCursor, false},
+		{Role(0), message.ActionMove, false},
+	}
+
+	for _, test := range tests {
+		if got := test.role.permits(test.action); got != test.want {
+			t.Errorf("%v sending %v: Want %v; Got %v", test.role, test.action, test.want, got)
+		}
+	}
+}
+
+func TestRoleMayErase(t *testing.T) {
+	tests := []struct {
+		role   Role
+		target uint16
+		want   bool
+	}{
+		{RoleOwner, 1, true},
+		{RoleOwner, 2, true},
+		{RoleEditor, 1, true},
+		{RoleEditor, 2, false},
+		{RoleViewer, 1, false},
+	}
+
+	for _, test := range tests {
+		if got := test.role.mayErase(1, test.target); got != test.want {
+			t.Errorf("%v erasing strokes of %d: Want %v; Got %v", test.role, test.target, test.want, got)
+		}
+	}
+}
+
+func TestRoles(t *testing.T) {
+	signer := auth.NewHMAC([]byte("a key for testing roles"))
+	token := func(claims auth.Claims) string {
+		token, err := signer.Sign(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	ts := newTestServerWith(t, &Store{Auth: signer, DefaultRole: RoleViewer})
+	defer ts.Close()
+
+	presenter := ts.dial("/rooms/board?token=" + token(auth.Claims{Role: "owner"}))
+	defer presenter.Close()
+
+	editor := ts.dial("/rooms/board?token=" + token(auth.Claims{Room: "board", Role: "editor"}))
+	defer editor.Close()
+
+	audience := ts.dial("/rooms/board?token=" + token(auth.Claims{}))
+	defer audience.Close()
+
+	// Refused messages go nowhere; the presenter's clear is what arrives.
+	send(t, audience, buildMessage(0, message.ActionDown, 1, 1))
+	send(t, editor, buildMessage(0, message.ActionClear, 0, 0))
+	time.Sleep(50 * time.Millisecond)
+	send(t, presenter, buildMessage(0, message.ActionClear, 0, 0))
+
+	for _, conn := range []*websocket.Conn{editor, audience} {
+		if msg := receive(t, conn); msg.Action() != message.ActionClear || msg.Sequence() != 1 {
+			t.Errorf("Want only the presenter's clear; Got %+v", msg.UnPack())
+		}
+	}
+
+	refusals := []struct {
+		path string
+		want int
+	}{
+		{"/rooms/board", http.StatusUnauthorized},
+		{"/rooms/board?token=guess", http.StatusUnauthorized},
+		{"/rooms/other?token=" + token(auth.Claims{Room: "board"}), http.StatusForbidden},
+		{"/rooms/board?token=" + token(auth.Claims{Role: "admin"}), http.StatusForbidden},
+	}
+
+	for _, refusal := range refusals {
+		_, response, err := websocket.DefaultDialer.Dial(ts.url(refusal.path), nil)
+		if err == nil || response.StatusCode != refusal.want {
+			t.Errorf("%s: Want status %d", refusal.path, refusal.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sync"
+
+	"backend/internal/collector"
+)
+
+// The board served at "/" lives in the root of the message store. It is opened
+// when the service starts and stays open until the service stops.
+const defaultRoom = ""
+
+var roomIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ValidRoomId reports whether id is usable as a room name. Room names become
+// directory names, so anything that could escape the message store is refused.
+func ValidRoomId(id string) bool {
+	return roomIdPattern.MatchString(id)
+}
+
+type room struct {
+	id        string
+	hub       *Hub
+	collector *collector.Collector
+
+	// Number of clients (and other holders) currently using the room.
+	refs int
+}
+
+// Rooms owns the Hub and Collector of every open room. A room is opened by the
+// first acquire and torn down by the last release.
+type Rooms struct {
+	store  *Store
+	mutex  sync.Mutex
+	rooms  map[string]*room
+	closed bool
+
+	// Connected clients, see drain.
+	connected int
+	draining  bool
+	drained   chan struct{}
+}
+
+func newRooms(store *Store) *Rooms {
+	return &Rooms{
+		store:   store,
+		rooms:   make(map[string]*room),
+		drained: make(chan struct{}),
+	}
+}
+
+func (rs *Rooms) acquire(id string) (*room, error) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if rs.closed {
+		return nil, fmt.Errorf("Rooms are closed.")
+	}
+
+	r, ok := rs.rooms[id]
+	if !ok {
+		var err error
+		if r, err = rs.open(id); err != nil {
+			return nil, err
+		}
+		rs.rooms[id] = r
+	}
+
+	r.refs = r.refs + 1
+
+	return r, nil
+}
+
+func (rs *Rooms) release(r *room) {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	if rs.closed {
+		return
+	}
+
+	r.refs = r.refs - 1
+	if r.refs > 0 {
+		return
+	}
+
+	delete(rs.rooms, r.id)
+	r.close()
+}
+
+func (rs *Rooms) closeAll() {
+	rs.mutex.Lock()
+	defer rs.mutex.Unlock()
+
+	rs.closed = true
+
+	for id, r := range rs.rooms {
+		delete(rs.rooms, id)
+		r.close()
+	}
+}
+
+func (rs *Rooms) open(id string) (*room, error) {
+	storePath, err := rs.store.CreateStore(id)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create store for room '%s': %v", id, err)
+	}
+
+	log := rs.store.logger().With("room", id)
+
+	c, err := collector.NewCollector(storePath, 0, log)
+	if err != nil {
+		return nil, err
+	}
+	c.Start()
+
+	hub := NewHub(id, c, rs.store.extent(), log)
+	hub.Start(context.Background())
+
+	return &room{id: id, hub: hub, collector: c}, nil
+}
+
+func (r *room) close() {
+	r.hub.Close()
+	r.collector.Stop()
+	<-r.collector.Finished
+}
+
+// compactAll compacts the log of every room in the store, opening rooms that
+// have no clients for the duration.
+func (rs *Rooms) compactAll(retain int) {
+	ids := []string{defaultRoom}
+
+	files, err := ioutil.ReadDir(rs.store.Directory)
+	if err != nil {
+		rs.store.logger().Error("Failed to list rooms for compaction", "err", err)
+		return
+	}
+
+	for _, file := range files {
+		if file.IsDir() && ValidRoomId(file.Name()) {
+			ids = append(ids, file.Name())
+		}
+	}
+
+	for _, id := range ids {
+		log := rs.store.logger().With("room", id)
+
+		r, err := rs.acquire(id)
+		if err != nil {
+			log.Error("Failed to open room for compaction", "err", err)
+			continue
+		}
+
+		result, err := r.collector.Compact(retain, rs.store.archiveFor(id))
+		rs.release(r)
+
+		if err != nil {
+			log.Error("Failed to compact room", "err", err)
+		} else {
+			log.Info("Compacted room", "records", result.Records, "bytes", result.Bytes, "archived", result.Archived)
+		}
+	}
+}
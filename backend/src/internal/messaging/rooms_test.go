@@ -0,0 +1,119 @@
+package messaging
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"backend/internal/message"
+)
+
+func TestValidRoomId(t *testing.T) {
+	valid := []string{"standup", "room-1", "Team_Board"}
+	for _, id := range valid {
+		if !ValidRoomId(id) {
+			t.Errorf("Expected '%s' to be a valid room id.", id)
+		}
+	}
+
+	invalid := []string{"", "..", "a/b", "room 1", "../../etc"}
+	for _, id := range invalid {
+		if ValidRoomId(id) {
+			t.Errorf("Expected '%s' to be an invalid room id.", id)
+		}
+	}
+}
+
+func TestRoomLifecycle(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messaging-rooms-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	rooms := newRooms(&Store{Directory: dir})
+
+	first, err := rooms.acquire("standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := rooms.acquire("standup")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Fatalf("Expected both acquires to share one room.")
+	}
+
+	if _, err := os.Stat(path.Join(dir, "standup", "collected.000000.bin")); err != nil {
+		t.Fatalf("Expected a collector log in the room directory: %v", err)
+	}
+
+	rooms.release(first)
+	if _, ok := rooms.rooms["standup"]; !ok {
+		t.Fatalf("Room was torn down while still in use.")
+	}
+
+	rooms.release(second)
+	if _, ok := rooms.rooms["standup"]; ok {
+		t.Fatalf("Room was not torn down after the last release.")
+	}
+
+	select {
+	case <-first.hub.done:
+	default:
+		t.Fatalf("Hub was not stopped after the last release.")
+	}
+
+	rooms.closeAll()
+}
+
+func TestCompactAllArchivesBesideTheStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "messaging-rooms-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	store := &Store{Directory: path.Join(dir, "message"), Archive: true}
+	rooms := newRooms(store)
+	defer rooms.closeAll()
+
+	for _, id := range []string{defaultRoom, "standup"} {
+		r, err := rooms.acquire(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, action := range []message.Action{message.ActionDown, message.ActionClear, message.ActionDown} {
+			r.collector.Collect(buildMessage(1, action, 0, 0), time.Now())
+		}
+		r.collector.Flush()
+		rooms.release(r)
+	}
+
+	// Twice, so that an archive taken for a room would be compacted too.
+	rooms.compactAll(0)
+	rooms.compactAll(0)
+
+	for _, archive := range []string{"collected.000000.bin", "standup/collected.000000.bin"} {
+		if _, err := os.Stat(path.Join(dir, "message-archive", archive)); err != nil {
+			t.Errorf("Want history archived beside the store: %v", err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(store.Directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if file.IsDir() && file.Name() != "standup" {
+			t.Errorf("Want only rooms in the store; Got %s", file.Name())
+		}
+	}
+}
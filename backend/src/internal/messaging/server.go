@@ -6,60 +6,108 @@ import (
 	"log"
 	"net/http"
 	"os"
-	"path"
-	"time"
 
-	"github.com/go-chi/chi"
+	"backend/internal/auth"
+	"backend/internal/certs"
+	"backend/internal/health"
+	"backend/internal/logging"
+	"backend/internal/metrics"
 
-	"backend/internal/collector"
+	"github.com/go-chi/chi"
 )
 
-func (store *Store) Serve(port int) {
-	r := chi.NewRouter()
+// Service is an open message store, ready to be served on a listener of its
+// own (see Serve) or mounted on someone else's.
+type Service struct {
+	store *Store
+	rooms *Rooms
+}
 
-	storePath, err := store.CreateStore()
-	if err != nil {
-		log.Fatalf("Failed to create message store: %v", err)
-	}
+// Open opens the store and holds its default board open until Close.
+func (store *Store) Open() (*Service, error) {
+	rooms := newRooms(store)
 
-	collectorPath := path.Join(storePath, "collected.bin")
-	collectorWriter, err := os.OpenFile(collectorPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, os.ModePerm)
-	if err != nil {
-		log.Fatal(err)
+	if _, err := rooms.acquire(defaultRoom); err != nil {
+		return nil, err
 	}
 
-	collectorReader, err := os.Open(collectorPath)
-	if err != nil {
-		log.Fatal(err)
+	return &Service{store: store, rooms: rooms}, nil
+}
+
+func (s *Service) Handler() http.Handler {
+	return s.store.routes(s.rooms)
+}
+
+// Run compacts the logs whenever the store is asked to, until it is stopped.
+func (s *Service) Run() {
+	for {
+		select {
+		case <-s.store.Compact:
+			s.rooms.compactAll(s.store.Retain)
+		case <-s.store.Stop:
+			return
+		}
 	}
+}
+
+// Close disconnects every client, telling them the server is restarting, and
+// closes every room once what they sent has been stored. It is called once
+// nothing new is being served.
+func (s *Service) Close() {
+	s.rooms.drain(s.store.ShutdownTimeout)
+	s.rooms.closeAll()
+}
+
+func (store *Store) routes(rooms *Rooms) http.Handler {
+	r := chi.NewRouter()
+
+	r.Use(logging.Middleware(store.logger()))
+
+	health.Mount(r, rooms.liveness, store.readiness)
 
-	collector := collector.NewCollector(collectorWriter, collectorReader)
-	collector.Start()
-	hub := newHub(collector)
-	go hub.run()
+	r.Group(func(r chi.Router) {
+		r.Use(auth.Middleware(store.Auth))
 
-	r.Get("/", GetHandler(hub))
+		r.Get("/", GetHandler(rooms))
+		r.Get("/metrics", metrics.Handler().ServeHTTP)
+		r.Get("/rooms/{id}", GetRoomHandler(rooms))
+	})
+
+	return r
+}
+
+func (store *Store) Serve(port int) {
+	logger := store.logger()
+
+	service, err := store.Open()
+	if err != nil {
+		logger.Error("Failed to create message store", "err", err)
+		os.Exit(1)
+	}
 
 	addr := fmt.Sprintf(":%d", port)
-	server := &http.Server{Addr: addr, Handler: r}
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   service.Handler(),
+		TLSConfig: store.TLS,
+		ErrorLog:  log.New(logger.Writer(logging.LevelWarn), "", 0)}
 
 	go func() {
-		log.Printf("—MESSAGESERVICE— serving HTTP on %s\n", addr)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Printf("—MESSAGESERVICE— unexpected error: %v", err)
+		logger.Info("Serving", "addr", addr)
+		if err := certs.ListenAndServe(server); err != http.ErrServerClosed {
+			logger.Error("Failed to serve", "addr", addr, "err", err)
 		}
 	}()
 
-	<-store.Stop
+	service.Run()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), store.ShutdownTimeout)
 
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("—MESSAGESERVICE— shutdown failed: %v\n", err)
+		logger.Error("Failed to shut down", "err", err)
 	}
 
-	collector.Stop()
-	<-collector.Finished
+	service.Close()
 }
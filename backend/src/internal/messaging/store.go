@@ -1,13 +1,86 @@
 package messaging
 
 import (
+	"crypto/tls"
 	"os"
 	"path"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/logging"
+	"backend/internal/origins"
 )
 
 type Store struct {
 	Directory string
 	Stop      chan struct{}
+
+	// Each receive compacts the log of every room, keeping Retain clears
+	// before the most recent one and archiving the rest if Archive is set.
+	Compact chan struct{}
+	Retain  int
+	Archive bool
+
+	// Where archived history goes, in a directory per room laid out like
+	// Directory's. Zero means Directory with "-archive" appended: beside
+	// the store, since anything inside it could be opened as a room.
+	ArchiveDirectory string
+
+	// Clients sending coordinates further than Extent from the origin are
+	// disconnected. Zero means DefaultExtent.
+	Extent float64
+
+	// Settings of every client's connection. Zero fields take the value
+	// in DefaultConnection.
+	Connection Connection
+
+	// How long Close waits for clients to leave, and Serve for requests
+	// to finish, before cutting them off. Zero waits for nothing.
+	ShutdownTimeout time.Duration
+
+	// Serves HTTPS if set, see certs.
+	TLS *tls.Config
+
+	// Rate limits applied to each client. The zero value limits nothing.
+	Limits Limits
+
+	// Authenticates every request, or nil to let anyone in.
+	Auth auth.Authenticator
+
+	// Other origins whose pages may open websockets.
+	Origins origins.List
+
+	// The role of clients whose claims do not name one. Zero means
+	// RoleOwner, so that a store without authentication is open to all.
+	DefaultRole Role
+
+	// Where the service logs, or nil for logging.Default.
+	Log *logging.Logger
+}
+
+func (s *Store) logger() *logging.Logger {
+	return s.Log.With("component", "messaging")
+}
+
+func (s *Store) extent() float64 {
+	if s.Extent <= 0 {
+		return DefaultExtent
+	}
+	return s.Extent
+}
+
+// archiveFor is where the history compacted away from a room is archived,
+// or "" if it is discarded.
+func (s *Store) archiveFor(room string) string {
+	if !s.Archive {
+		return ""
+	}
+
+	directory := s.ArchiveDirectory
+	if directory == "" {
+		directory = path.Clean(s.Directory) + "-archive"
+	}
+	return path.Join(directory, room)
 }
 
 func (s *Store) CreateStore(parts ...string) (string, error) {
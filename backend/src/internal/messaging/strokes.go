@@ -0,0 +1,126 @@
+package messaging
+
+import (
+	"backend/internal/collector"
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// A stroke is identified by the client that drew it and the id it was given.
+type strokeKey struct {
+	ClientId uint16
+	Id       int32
+}
+
+// What coalesce does with each record.
+type fate int
+
+const (
+	fateRaw fate = iota
+	fateDrop
+	fatePolyline
+)
+
+// coalesce replaces every completed stroke in records with a single
+// ActionPolyline message, sent at the position (and with the sequence number)
+// of the stroke's Up. Strokes ended by ActionCancel are dropped altogether.
+// Strokes still being drawn, and everything that is not part of a stroke,
+// are passed through unchanged.
+func coalesce(records []collector.Record) [][]byte {
+	fates := make([]fate, len(records))
+	strokes := make(map[int][]int)
+	open := make(map[strokeKey][]int)
+
+	for idx := range records {
+		msg, ok := decode(records[idx].Data)
+		if !ok {
+			continue
+		}
+
+		key := strokeKey{ClientId: msg.ClientId(), Id: msg.Id()}
+		stroke, drawing := open[key]
+
+		switch msg.Action() {
+		case message.ActionDown:
+			// A stroke that was never finished stays as it was sent.
+			open[key] = []int{idx}
+
+		case message.ActionMove:
+			if drawing {
+				open[key] = append(stroke, idx)
+			}
+
+		case message.ActionUp:
+			if drawing {
+				stroke = append(stroke, idx)
+				for _, member := range stroke {
+					fates[member] = fateDrop
+				}
+				fates[idx] = fatePolyline
+				strokes[idx] = stroke
+				delete(open, key)
+			}
+
+		case message.ActionCancel:
+			fates[idx] = fateDrop
+			if drawing {
+				for _, member := range stroke {
+					fates[member] = fateDrop
+				}
+				delete(open, key)
+			}
+		}
+	}
+
+	messages := [][]byte{}
+	for idx := range records {
+		switch fates[idx] {
+		case fateRaw:
+			messages = append(messages, withSequence(records[idx]))
+		case fatePolyline:
+			messages = append(messages, polyline(records, strokes[idx]))
+		}
+	}
+
+	return messages
+}
+
+func decode(payload []byte) (msg *message.Message, ok bool) {
+	defer func() {
+		if err := recover(); err != nil {
+			msg, ok = nil, false
+		}
+	}()
+
+	msg = message.GetRootAsMessage(payload, 0)
+	msg.Action()
+
+	return msg, true
+}
+
+// polyline builds one message from the records of a completed stroke.
+func polyline(records []collector.Record, stroke []int) []byte {
+	last := records[stroke[len(stroke)-1]]
+	up := message.GetRootAsMessage(last.Data, 0)
+
+	points := []*message.CoordinateT{}
+	for _, idx := range stroke {
+		if point := message.GetRootAsMessage(records[idx].Data, 0).Data(nil); point != nil {
+			points = append(points, point.UnPack())
+		}
+	}
+
+	msg := message.MessageT{
+		ClientId: up.ClientId(),
+		Id:       up.Id(),
+		Action:   message.ActionPolyline,
+		Sequence: last.Sequence,
+		Polyline: &message.PolylineT{Points: points},
+	}
+
+	builder := flatbuffers.NewBuilder(64 + len(points)*8)
+	builder.Finish(msg.Pack(builder))
+
+	return builder.FinishedBytes()
+}
@@ -0,0 +1,69 @@
+package messaging
+
+import (
+	"testing"
+
+	"backend/internal/collector"
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+func buildStrokeMessage(clientId uint16, id int32, action message.Action, x, y float32) []byte {
+	builder := flatbuffers.NewBuilder(80)
+	message.MessageStart(builder)
+	message.MessageAddClientId(builder, clientId)
+	message.MessageAddAction(builder, action)
+	message.MessageAddData(builder, message.CreateCoordinate(builder, x, y))
+	message.MessageAddId(builder, id)
+	msg := message.MessageEnd(builder)
+	builder.Finish(msg)
+	return builder.FinishedBytes()
+}
+
+func TestCoalesce(t *testing.T) {
+	payloads := [][]byte{
+		buildStrokeMessage(1, 7, message.ActionDown, 0, 0),
+		buildStrokeMessage(2, 7, message.ActionDown, 5, 5),
+		buildStrokeMessage(1, 7, message.ActionMove, 1, 1),
+		buildStrokeMessage(2, 7, message.ActionMove, 6, 6),
+		buildStrokeMessage(1, 7, message.ActionUp, 2, 2),
+		buildStrokeMessage(2, 7, message.ActionCancel, 0, 0),
+		buildStrokeMessage(1, 8, message.ActionDown, 9, 9),
+		buildStrokeMessage(1, 8, message.ActionMove, 8, 8),
+	}
+
+	records := []collector.Record{}
+	for idx := range payloads {
+		records = append(records, collector.Record{Sequence: uint64(idx + 1), Data: payloads[idx]})
+	}
+
+	got := coalesce(records)
+
+	/* One polyline for client 1's first stroke, nothing for client 2's cancelled
+	stroke, and the raw messages of the stroke still being drawn. */
+	if len(got) != 3 {
+		t.Fatalf("Want 3 messages; Got %d", len(got))
+	}
+
+	line := message.GetRootAsMessage(got[0], 0)
+	if line.Action() != message.ActionPolyline || line.ClientId() != 1 || line.Id() != 7 {
+		t.Fatalf("Want a polyline for stroke 1/7; Got %+v", line.UnPack())
+	}
+
+	if line.Sequence() != 5 {
+		t.Errorf("Want the polyline to carry the sequence of its Up (5); Got %d", line.Sequence())
+	}
+
+	points := line.Polyline(nil).UnPack().Points
+	if len(points) != 3 || points[0].X != 0 || points[1].X != 1 || points[2].X != 2 {
+		t.Errorf("Polyline points are wrong: %v", line.Polyline(nil).UnPack())
+	}
+
+	for idx, want := range []uint64{7, 8} {
+		msg := message.GetRootAsMessage(got[idx+1], 0)
+		if msg.Sequence() != want || msg.Id() != 8 {
+			t.Errorf("Want raw message %d of stroke 1/8; Got %+v", want, msg.UnPack())
+		}
+	}
+}
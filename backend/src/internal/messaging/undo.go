@@ -0,0 +1,213 @@
+package messaging
+
+import (
+	"backend/internal/collector"
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+)
+
+// Completed strokes a client can undo, counting back from its latest.
+const maxUndo = 256
+
+// strokeHistory keeps, for each client in a hub, the strokes it completed and
+// the ones it undid, so that ActionUndo and ActionRedo can be resolved to a
+// stroke; an erased stroke is taken out of its client's history. A client's
+// history goes when it leaves: the id it had may be given to someone else.
+type strokeHistory struct {
+	clients map[uint16]*undoStacks
+}
+
+type undoStacks struct {
+	// Strokes being drawn.
+	open map[int32]bool
+
+	// Completed strokes, latest last, and strokes undone, latest undone
+	// last.
+	done   []int32
+	undone []int32
+}
+
+func newStrokeHistory() *strokeHistory {
+	return &strokeHistory{clients: make(map[uint16]*undoStacks)}
+}
+
+func (sh *strokeHistory) stacks(id uint16) *undoStacks {
+	stacks, ok := sh.clients[id]
+	if !ok {
+		stacks = &undoStacks{open: make(map[int32]bool)}
+		sh.clients[id] = stacks
+	}
+	return stacks
+}
+
+// track follows the strokes of a client's message. A new stroke cannot be
+// redone over, so completing one forgets what was undone.
+func (sh *strokeHistory) track(id uint16, msg *message.Message) {
+	switch msg.Action() {
+	case message.ActionClear:
+		// Nothing drawn before a clear is left to undo.
+		sh.clients = make(map[uint16]*undoStacks)
+
+	case message.ActionDown:
+		sh.stacks(id).open[msg.Id()] = true
+
+	case message.ActionCancel:
+		delete(sh.stacks(id).open, msg.Id())
+
+	case message.ActionErase:
+		// An erased stroke is gone for good, whoever drew it.
+		if stacks, ok := sh.clients[msg.Target()]; ok {
+			stacks.done = without(stacks.done, msg.Id())
+			stacks.undone = without(stacks.undone, msg.Id())
+		}
+
+	case message.ActionUp:
+		stacks := sh.stacks(id)
+		if !stacks.open[msg.Id()] {
+			break
+		}
+		delete(stacks.open, msg.Id())
+
+		stacks.done = append(stacks.done, msg.Id())
+		if len(stacks.done) > maxUndo {
+			stacks.done = stacks.done[len(stacks.done)-maxUndo:]
+		}
+		stacks.undone = nil
+	}
+}
+
+// resolve returns the stroke an ActionUndo or ActionRedo of the client
+// applies to, moving it between the stacks, or false if there is none.
+func (sh *strokeHistory) resolve(id uint16, action message.Action) (int32, bool) {
+	stacks := sh.stacks(id)
+
+	from, to := &stacks.done, &stacks.undone
+	if action == message.ActionRedo {
+		from, to = to, from
+	}
+
+	if len(*from) == 0 {
+		return 0, false
+	}
+
+	stroke := (*from)[len(*from)-1]
+	*from = (*from)[:len(*from)-1]
+	*to = append(*to, stroke)
+
+	return stroke, true
+}
+
+func (sh *strokeHistory) leave(id uint16) {
+	delete(sh.clients, id)
+}
+
+func without(strokes []int32, id int32) []int32 {
+	kept := strokes[:0]
+	for _, stroke := range strokes {
+		if stroke != id {
+			kept = append(kept, stroke)
+		}
+	}
+	return kept
+}
+
+// isUndo reports whether the action is ActionUndo or ActionRedo.
+func isUndo(action message.Action) bool {
+	return action == message.ActionUndo || action == message.ActionRedo
+}
+
+// withStrokeId returns the payload with its stroke id replaced, for undo and
+// redo messages resolved by the hub.
+func withStrokeId(payload []byte, id int32) []byte {
+	msg := message.GetRootAsMessage(payload, 0)
+	if msg.Id() == id || msg.MutateId(id) {
+		return payload
+	}
+
+	rebuilt := msg.UnPack()
+	rebuilt.Id = id
+
+	builder := flatbuffers.NewBuilder(len(payload) + 8)
+	builder.Finish(rebuilt.Pack(builder))
+
+	return builder.FinishedBytes()
+}
+
+// An occurrence of a stroke in records: a client may use a stroke id again,
+// and client ids wrap around after 65535.
+type strokeRecords struct {
+	start   int
+	members []int
+	undone  bool
+	erased  bool
+}
+
+// withoutRemoved leaves undone and erased strokes out of playback, which is
+// the tail of records, the history since the last clear. Undo, redo and
+// erase messages are left out too, unless their stroke precedes playback:
+// the client has that stroke, and needs to be told what became of it.
+func withoutRemoved(records []collector.Record, playback []collector.Record) []collector.Record {
+	offset := len(records) - len(playback)
+
+	drop := make([]bool, len(records))
+	strokes := []*strokeRecords{}
+	latest := make(map[strokeKey]*strokeRecords)
+	removals := make(map[int]*strokeRecords)
+
+	for idx := range records {
+		msg, ok := decode(records[idx].Data)
+		if !ok {
+			continue
+		}
+
+		key := strokeKey{ClientId: msg.ClientId(), Id: msg.Id()}
+		stroke := latest[key]
+
+		switch action := msg.Action(); {
+		case action == message.ActionDown:
+			stroke = &strokeRecords{start: idx, members: []int{idx}}
+			strokes = append(strokes, stroke)
+			latest[key] = stroke
+
+		case action == message.ActionMove || action == message.ActionUp || action == message.ActionCancel:
+			if stroke != nil {
+				stroke.members = append(stroke.members, idx)
+			}
+
+		case isUndo(action):
+			if stroke != nil {
+				stroke.undone = action == message.ActionUndo
+				removals[idx] = stroke
+			}
+
+		case action == message.ActionErase:
+			if stroke := latest[strokeKey{ClientId: msg.Target(), Id: msg.Id()}]; stroke != nil {
+				stroke.erased = true
+				removals[idx] = stroke
+			}
+		}
+	}
+
+	for _, stroke := range strokes {
+		if stroke.undone || stroke.erased {
+			for _, member := range stroke.members {
+				drop[member] = true
+			}
+		}
+	}
+	for idx, stroke := range removals {
+		if stroke.start >= offset {
+			drop[idx] = true
+		}
+	}
+
+	kept := make([]collector.Record, 0, len(playback))
+	for idx := offset; idx < len(records); idx++ {
+		if !drop[idx] {
+			kept = append(kept, records[idx])
+		}
+	}
+
+	return kept
+}
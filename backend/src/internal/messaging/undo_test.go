@@ -0,0 +1,218 @@
+package messaging
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/collector"
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/gorilla/websocket"
+)
+
+func buildErase(target uint16, id int32) []byte {
+	msg := message.MessageT{Action: message.ActionErase, Target: target, Id: id}
+
+	builder := flatbuffers.NewBuilder(32)
+	builder.Finish(msg.Pack(builder))
+
+	return builder.FinishedBytes()
+}
+
+func track(sh *strokeHistory, clientId uint16, id int32, actions ...message.Action) {
+	for _, action := range actions {
+		sh.track(clientId, message.GetRootAsMessage(buildStrokeMessage(clientId, id, action, 0, 0), 0))
+	}
+}
+
+func TestStrokeHistory(t *testing.T) {
+	sh := newStrokeHistory()
+
+	track(sh, 1, 10, message.ActionDown, message.ActionMove, message.ActionUp)
+	track(sh, 1, 11, message.ActionDown, message.ActionUp)
+	track(sh, 1, 12, message.ActionDown, message.ActionCancel)
+	track(sh, 2, 20, message.ActionDown, message.ActionUp)
+
+	steps := []struct {
+		action message.Action
+		stroke int32
+		ok     bool
+	}{
+		{message.ActionUndo, 11, true},
+		{message.ActionUndo, 10, true},
+		{message.ActionUndo, 0, false},
+		{message.ActionRedo, 10, true},
+		{message.ActionUndo, 10, true},
+	}
+	for idx, step := range steps {
+		stroke, ok := sh.resolve(1, step.action)
+		if stroke != step.stroke || ok != step.ok {
+			t.Errorf("%d: Want %s of stroke %d, %v; Got %d, %v", idx, step.action, step.stroke, step.ok, stroke, ok)
+		}
+	}
+
+	// A new stroke leaves nothing to redo.
+	track(sh, 1, 13, message.ActionDown, message.ActionUp)
+	if _, ok := sh.resolve(1, message.ActionRedo); ok {
+		t.Errorf("Want nothing to redo after a new stroke.")
+	}
+
+	// An id given to another client comes without the old strokes.
+	sh.leave(2)
+	if _, ok := sh.resolve(2, message.ActionUndo); ok {
+		t.Errorf("Want nothing to undo for a client that left.")
+	}
+
+	track(sh, 3, 0, message.ActionClear)
+	if _, ok := sh.resolve(1, message.ActionUndo); ok {
+		t.Errorf("Want nothing to undo after a clear.")
+	}
+}
+
+func TestWithoutUndone(t *testing.T) {
+	data := [][]byte{
+		buildStrokeMessage(1, 1, message.ActionDown, 0, 0),
+		buildStrokeMessage(1, 1, message.ActionUp, 1, 1),
+		buildStrokeMessage(1, 2, message.ActionDown, 0, 0),
+		buildStrokeMessage(1, 2, message.ActionUp, 1, 1),
+		buildStrokeMessage(1, 2, message.ActionUndo, 0, 0),
+		buildStrokeMessage(1, 1, message.ActionUndo, 0, 0),
+		buildStrokeMessage(1, 1, message.ActionRedo, 0, 0),
+		// The id drawn again is a new stroke.
+		buildStrokeMessage(1, 2, message.ActionDown, 0, 0),
+		buildStrokeMessage(1, 2, message.ActionUp, 1, 1),
+		buildStrokeMessage(2, 1, message.ActionDown, 0, 0),
+		buildStrokeMessage(2, 1, message.ActionUp, 1, 1),
+		buildErase(2, 1),
+	}
+
+	records := []collector.Record{}
+	for idx := range data {
+		records = append(records, collector.Record{Sequence: uint64(idx + 1), Data: data[idx]})
+	}
+
+	tests := []struct {
+		since uint64
+		want  []uint64
+	}{
+		// Undone strokes are left out, along with their undos and redos.
+		// So are erased strokes, and their erases.
+		{0, []uint64{1, 2, 8, 9}},
+
+		// A client that has both strokes is told what became of them.
+		{4, []uint64{5, 6, 7, 8, 9}},
+
+		// Stroke 1 began before playback, stroke 2 did not.
+		{2, []uint64{6, 7, 8, 9}},
+
+		// A client that has the erased stroke is told it went.
+		{11, []uint64{12}},
+	}
+	for _, test := range tests {
+		got := sequencesOf(withoutRemoved(records, resumeFrom(records, test.since)))
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("Since %d: Want %v; Got %v", test.since, test.want, got)
+		}
+	}
+}
+
+func TestUndo(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	drawer, id := ts.join("/rooms/undo")
+	defer drawer.Close()
+	viewer := ts.dial("/rooms/undo")
+	defer viewer.Close()
+
+	for _, stroke := range []int32{5, 6} {
+		send(t, drawer, buildStrokeMessage(0, stroke, message.ActionDown, 1, 1))
+		send(t, drawer, buildStrokeMessage(0, stroke, message.ActionUp, 2, 2))
+	}
+	expectSequences(t, viewer, 1, 2, 3, 4)
+
+	send(t, drawer, buildStrokeMessage(0, 0, message.ActionUndo, 0, 0))
+
+	// Both are told which stroke went, the drawer included.
+	for _, conn := range []*websocket.Conn{viewer, drawer} {
+		msg := receive(t, conn)
+		if msg.Action() != message.ActionUndo || msg.ClientId() != id || msg.Id() != 6 || msg.Sequence() != 5 {
+			t.Errorf("Want the undo of stroke 6; Got %+v", msg.UnPack())
+		}
+	}
+
+	// Stroke 5 goes too, after which an undo has nothing to take and is
+	// dropped.
+	send(t, drawer, buildStrokeMessage(0, 0, message.ActionUndo, 0, 0))
+	receive(t, viewer)
+	send(t, drawer, buildStrokeMessage(0, 0, message.ActionUndo, 0, 0))
+	send(t, drawer, buildStrokeMessage(0, 0, message.ActionRedo, 0, 0))
+
+	if msg := receive(t, viewer); msg.Action() != message.ActionRedo || msg.Id() != 5 || msg.Sequence() != 7 {
+		t.Errorf("Want the redo of stroke 5; Got %+v", msg.UnPack())
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	// Undone strokes are not played back.
+	late := ts.dial("/rooms/undo")
+	defer late.Close()
+
+	expectSequences(t, late, 1, 2)
+
+	send(t, drawer, buildStrokeMessage(0, 7, message.ActionDown, 1, 1))
+	expectSequences(t, late, 8)
+}
+
+func TestErase(t *testing.T) {
+	signer := auth.NewHMAC([]byte("a key for testing erase"))
+	token := func(role string) string {
+		token, err := signer.Sign(auth.Claims{Role: role})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	ts := newTestServerWith(t, &Store{Auth: signer})
+	defer ts.Close()
+
+	facilitator, facilitatorId := ts.join("/rooms/erase?token=" + token("owner"))
+	defer facilitator.Close()
+	editor, editorId := ts.join("/rooms/erase?token=" + token("editor"))
+	defer editor.Close()
+
+	for _, stroke := range []int32{1, 2} {
+		send(t, editor, buildStrokeMessage(0, stroke, message.ActionDown, 1, 1))
+		send(t, editor, buildStrokeMessage(0, stroke, message.ActionUp, 2, 2))
+	}
+	expectSequences(t, facilitator, 1, 2, 3, 4)
+
+	// Editors may only erase their own strokes.
+	send(t, editor, buildErase(facilitatorId, 1))
+	send(t, editor, buildErase(editorId, 1))
+
+	if msg := receive(t, facilitator); msg.Action() != message.ActionErase || msg.Target() != editorId || msg.Id() != 1 {
+		t.Errorf("Want the editor's erase of its stroke 1; Got %+v", msg.UnPack())
+	}
+
+	send(t, facilitator, buildErase(editorId, 2))
+
+	if msg := receive(t, editor); msg.Action() != message.ActionErase || msg.ClientId() != facilitatorId || msg.Sequence() != 6 {
+		t.Errorf("Want the facilitator's erase of stroke 2; Got %+v", msg.UnPack())
+	}
+
+	// Erased strokes cannot be brought back, nor undone.
+	send(t, editor, buildStrokeMessage(0, 0, message.ActionUndo, 0, 0))
+
+	time.Sleep(100 * time.Millisecond)
+
+	late := ts.dial("/rooms/erase?token=" + token("viewer"))
+	defer late.Close()
+
+	send(t, facilitator, buildStrokeMessage(0, 3, message.ActionDown, 1, 1))
+	expectSequences(t, late, 7)
+}
@@ -0,0 +1,168 @@
+package messaging
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"backend/internal/message"
+)
+
+// Coordinates further than this from the origin are refused, unless the store
+// sets its own extent.
+const DefaultExtent = 1e6
+
+// The actions a client may send. The rest are only ever sent by the server.
+var clientActions = map[message.Action]bool{
+	message.ActionUp:     true,
+	message.ActionDown:   true,
+	message.ActionMove:   true,
+	message.ActionClear:  true,
+	message.ActionCursor: true,
+	message.ActionCancel: true,
+	message.ActionUndo:   true,
+	message.ActionRedo:   true,
+	message.ActionErase:  true,
+}
+
+// validate checks a payload before it reaches the hub, so that a buggy client
+// cannot hand malformed bytes to everyone else's decoder. The error explains
+// what is wrong and is sent back to the client as its close reason.
+func validate(payload []byte, extent float64) (message.Action, error) {
+	if err := verifyMessage(payload); err != nil {
+		return 0, err
+	}
+
+	msg := message.GetRootAsMessage(payload, 0)
+
+	action := msg.Action()
+	if !clientActions[action] {
+		return action, fmt.Errorf("action %d not allowed", action)
+	}
+
+	if data := msg.Data(nil); data != nil {
+		for _, value := range []float32{data.X(), data.Y()} {
+			v := float64(value)
+			if math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) > extent {
+				return action, fmt.Errorf("coordinate %v out of bounds", value)
+			}
+		}
+	}
+
+	return action, nil
+}
+
+// Generated Go code has no verifier, so verifyMessage checks by hand that
+// every offset the Message accessors follow stays inside the buffer.
+func verifyMessage(buf []byte) error {
+	if len(buf) < 8 {
+		return fmt.Errorf("message too short")
+	}
+
+	root, err := verifyTable(buf, int(binary.LittleEndian.Uint32(buf)))
+	if err != nil {
+		return err
+	}
+
+	// Vtable offsets and sizes of the Message fields, see message.fbs.
+	fields := []struct {
+		slot int
+		size int
+	}{
+		{4, 2},  // clientId
+		{6, 4},  // id
+		{8, 8},  // data
+		{10, 1}, // action
+		{12, 8}, // sequence
+		{14, 4}, // polyline
+		{16, 2}, // target
+	}
+
+	for _, field := range fields {
+		if _, _, err := root.field(field.slot, field.size); err != nil {
+			return err
+		}
+	}
+
+	pos, ok, _ := root.field(14, 4)
+	if !ok {
+		return nil
+	}
+
+	polyline, err := verifyTable(buf, pos+int(binary.LittleEndian.Uint32(buf[pos:])))
+	if err != nil {
+		return err
+	}
+
+	pos, ok, err = polyline.field(4, 4)
+	if err != nil || !ok {
+		return err
+	}
+
+	vector := pos + int(binary.LittleEndian.Uint32(buf[pos:]))
+	if !inBounds(buf, vector, 4) {
+		return fmt.Errorf("polyline points out of bounds")
+	}
+
+	count := int(binary.LittleEndian.Uint32(buf[vector:]))
+	if count > len(buf)/8 || !inBounds(buf, vector+4, count*8) {
+		return fmt.Errorf("polyline points out of bounds")
+	}
+
+	return nil
+}
+
+type verifiedTable struct {
+	buf    []byte
+	pos    int
+	vtable int
+	vsize  int
+	size   int
+}
+
+func verifyTable(buf []byte, pos int) (*verifiedTable, error) {
+	if !inBounds(buf, pos, 4) {
+		return nil, fmt.Errorf("table out of bounds")
+	}
+
+	vtable := pos - int(int32(binary.LittleEndian.Uint32(buf[pos:])))
+	if !inBounds(buf, vtable, 4) {
+		return nil, fmt.Errorf("vtable out of bounds")
+	}
+
+	vsize := int(binary.LittleEndian.Uint16(buf[vtable:]))
+	size := int(binary.LittleEndian.Uint16(buf[vtable+2:]))
+
+	if vsize < 4 || vsize%2 != 0 || !inBounds(buf, vtable, vsize) {
+		return nil, fmt.Errorf("vtable out of bounds")
+	}
+
+	if size < 4 || !inBounds(buf, pos, size) {
+		return nil, fmt.Errorf("table out of bounds")
+	}
+
+	return &verifiedTable{buf: buf, pos: pos, vtable: vtable, vsize: vsize, size: size}, nil
+}
+
+// field returns the position of the field in the given vtable slot, and false
+// if the table does not have it.
+func (t *verifiedTable) field(slot int, size int) (int, bool, error) {
+	if slot+2 > t.vsize {
+		return 0, false, nil
+	}
+
+	offset := int(binary.LittleEndian.Uint16(t.buf[t.vtable+slot:]))
+	if offset == 0 {
+		return 0, false, nil
+	}
+
+	if offset+size > t.size {
+		return 0, false, fmt.Errorf("field out of bounds")
+	}
+
+	return t.pos + offset, true, nil
+}
+
+func inBounds(buf []byte, pos int, size int) bool {
+	return pos >= 0 && size >= 0 && pos+size <= len(buf)
+}
@@ -0,0 +1,86 @@
+package messaging
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"backend/internal/message"
+
+	flatbuffers "github.com/google/flatbuffers/go"
+	"github.com/gorilla/websocket"
+)
+
+func buildClear() []byte {
+	builder := flatbuffers.NewBuilder(32)
+	message.MessageStart(builder)
+	message.MessageAddAction(builder, message.ActionClear)
+	builder.Finish(message.MessageEnd(builder))
+	return builder.FinishedBytes()
+}
+
+func TestValidate(t *testing.T) {
+	valid := buildMessage(1, message.ActionMove, 10, 20)
+
+	// Point the root at a vtable far outside the buffer.
+	badVtable := append([]byte{}, valid...)
+	root := int(flatbuffers.GetUOffsetT(badVtable))
+	flatbuffers.WriteSOffsetT(badVtable[root:], -100000)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		ok      bool
+	}{
+		{"valid", valid, true},
+		{"no coordinate", buildClear(), true},
+		{"empty", []byte{}, false},
+		{"truncated", valid[:len(valid)/2], false},
+		{"garbage", []byte{0xff, 0xff, 0xff, 0x7f, 1, 2, 3, 4, 5, 6, 7, 8}, false},
+		{"bad vtable", badVtable, false},
+		{"unknown action", buildMessage(1, message.Action(42), 0, 0), false},
+		{"server action", buildMessage(1, message.ActionWelcome, 0, 0), false},
+		{"not a number", buildMessage(1, message.ActionMove, float32(math.NaN()), 0), false},
+		{"infinite", buildMessage(1, message.ActionMove, 0, float32(math.Inf(-1))), false},
+		{"off the board", buildMessage(1, message.ActionMove, 0, 5000), false},
+	}
+
+	for _, test := range tests {
+		_, err := validate(test.payload, 1000)
+		if test.ok && err != nil {
+			t.Errorf("%s: Want valid; Got %v", test.name, err)
+		}
+		if !test.ok && err == nil {
+			t.Errorf("%s: Want an error", test.name)
+		}
+	}
+}
+
+func TestInvalidMessageDisconnects(t *testing.T) {
+	ts := newTestServer(t)
+	defer ts.Close()
+
+	buggy := ts.dial("/rooms/board")
+	defer buggy.Close()
+
+	viewer := ts.dial("/rooms/board")
+	defer viewer.Close()
+
+	send(t, buggy, []byte{0xff, 0xff, 0xff, 0x7f, 1, 2, 3, 4})
+
+	_, _, err := buggy.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseInvalidFramePayloadData) {
+		t.Fatalf("Want the buggy client closed as invalid; Got %v", err)
+	}
+
+	// The others never see the bad message, and keep drawing.
+	send(t, viewer, buildMessage(1, message.ActionMove, 1, 1))
+	time.Sleep(100 * time.Millisecond)
+
+	late := ts.dial("/rooms/board")
+	defer late.Close()
+
+	if msg := receive(t, late); msg.Action() != message.ActionMove {
+		t.Errorf("Want only the valid message stored; Got %+v", msg.UnPack())
+	}
+}
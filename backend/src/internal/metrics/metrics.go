@@ -0,0 +1,384 @@
+// Package metrics counts what the services do and serves the counts in the
+// Prometheus text format, for dashboards and alerts on board health.
+//
+// Metrics are declared once, as package variables registered with Default,
+// and broken down by labels:
+//
+//	var requests = metrics.Default.NewCounter("requests_total", "Requests, by method.", "method")
+//
+//	requests.With(r.Method).Inc()
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Registry holds every metric it serves, in the order they were declared.
+type Registry struct {
+	mutex    sync.Mutex
+	families []*family
+	names    map[string]bool
+}
+
+// Default is the registry of everything the services count.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// A metric of one family, for one set of label values.
+type metric interface {
+	write(w io.Writer, name string, labels []string)
+}
+
+// A family is a metric broken down by labels: one series per combination of
+// label values seen so far.
+type family struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+
+	// Creates the metric of a new series.
+	create func() metric
+
+	mutex  sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	values []string
+	metric metric
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, create func() metric) *family {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.names[name] {
+		panic(fmt.Sprintf("metric %s declared twice", name))
+	}
+	r.names[name] = true
+
+	f := &family{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		create: create,
+		series: make(map[string]*series),
+	}
+	r.families = append(r.families, f)
+
+	return f
+}
+
+func seriesKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// with returns the metric for the label values, creating it on first use.
+func (f *family) with(values []string) metric {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, not %d", f.name, len(f.labels), len(values)))
+	}
+
+	key := seriesKey(values)
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...), metric: f.create()}
+		f.series[key] = s
+	}
+
+	return s.metric
+}
+
+func (f *family) delete(values []string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	delete(f.series, seriesKey(values))
+}
+
+func (f *family) write(w io.Writer) {
+	f.mutex.Lock()
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	all := make([]*series, len(keys))
+	for idx, key := range keys {
+		all[idx] = f.series[key]
+	}
+	f.mutex.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escape(f.help, false))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	for _, s := range all {
+		labels := make([]string, len(f.labels))
+		for idx, label := range f.labels {
+			labels[idx] = fmt.Sprintf(`%s="%s"`, label, escape(s.values[idx], true))
+		}
+		s.metric.write(w, f.name, labels)
+	}
+}
+
+// Write writes every metric in the Prometheus text format.
+func (r *Registry) Write(w io.Writer) {
+	r.mutex.Lock()
+	families := append([]*family(nil), r.families...)
+	r.mutex.Unlock()
+
+	for _, f := range families {
+		f.write(w)
+	}
+}
+
+// Handler serves the registry to Prometheus.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.Write(w)
+	})
+}
+
+// Handler serves Default.
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+func escape(s string, quoted bool) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	if quoted {
+		s = strings.Replace(s, `"`, `\"`, -1)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeSample(w io.Writer, name string, labels []string, value float64) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+	} else {
+		fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(labels, ","), formatFloat(value))
+	}
+}
+
+// A float64 updated atomically.
+type value struct {
+	bits uint64
+}
+
+func (v *value) add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&v.bits)
+		updated := math.Float64bits(math.Float64frombits(old) + delta)
+		if atomic.CompareAndSwapUint64(&v.bits, old, updated) {
+			return
+		}
+	}
+}
+
+func (v *value) set(to float64) {
+	atomic.StoreUint64(&v.bits, math.Float64bits(to))
+}
+
+func (v *value) load() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&v.bits))
+}
+
+// A Counter only goes up, until the process restarts.
+type Counter struct {
+	value
+}
+
+func (c *Counter) Inc() {
+	c.add(1)
+}
+
+// Add increases the counter. Negative amounts are ignored.
+func (c *Counter) Add(delta float64) {
+	if delta > 0 {
+		c.add(delta)
+	}
+}
+
+func (c *Counter) Value() float64 {
+	return c.load()
+}
+
+func (c *Counter) write(w io.Writer, name string, labels []string) {
+	writeSample(w, name, labels, c.load())
+}
+
+// A Gauge goes up and down.
+type Gauge struct {
+	value
+}
+
+func (g *Gauge) Set(to float64) {
+	g.set(to)
+}
+
+func (g *Gauge) Add(delta float64) {
+	g.add(delta)
+}
+
+func (g *Gauge) Inc() {
+	g.add(1)
+}
+
+func (g *Gauge) Dec() {
+	g.add(-1)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.load()
+}
+
+func (g *Gauge) write(w io.Writer, name string, labels []string) {
+	writeSample(w, name, labels, g.load())
+}
+
+// A Histogram counts observations in buckets by their upper bound.
+type Histogram struct {
+	bounds []float64
+
+	mutex  sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *Histogram) Observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if idx := sort.SearchFloat64s(h.bounds, v); idx < len(h.bounds) {
+		h.counts[idx] = h.counts[idx] + 1
+	}
+	h.count = h.count + 1
+	h.sum = h.sum + v
+}
+
+// Count is the number of observations so far.
+func (h *Histogram) Count() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.count
+}
+
+func (h *Histogram) Sum() float64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	return h.sum
+}
+
+func (h *Histogram) write(w io.Writer, name string, labels []string) {
+	h.mutex.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	count, sum := h.count, h.sum
+	h.mutex.Unlock()
+
+	cumulative := uint64(0)
+	for idx, bound := range h.bounds {
+		cumulative = cumulative + counts[idx]
+		le := fmt.Sprintf(`le="%s"`, formatFloat(bound))
+		writeSample(w, name+"_bucket", append(labels[:len(labels):len(labels)], le), float64(cumulative))
+	}
+	writeSample(w, name+"_bucket", append(labels[:len(labels):len(labels)], `le="+Inf"`), float64(count))
+	writeSample(w, name+"_sum", labels, sum)
+	writeSample(w, name+"_count", labels, float64(count))
+}
+
+// ExponentialBuckets returns count bucket bounds, the first one start and
+// each one factor times the last.
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for idx := range buckets {
+		buckets[idx] = start
+		start = start * factor
+	}
+	return buckets
+}
+
+type CounterVec struct {
+	family *family
+}
+
+// NewCounter declares a counter with the given labels.
+func (r *Registry) NewCounter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, "counter", labels, func() metric { return &Counter{} })}
+}
+
+// With returns the counter for the label values, in the order of the labels.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.family.with(values).(*Counter)
+}
+
+type GaugeVec struct {
+	family *family
+}
+
+// NewGauge declares a gauge with the given labels.
+func (r *Registry) NewGauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, "gauge", labels, func() metric { return &Gauge{} })}
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return v.family.with(values).(*Gauge)
+}
+
+// Delete drops the series of the label values, such as for something that no
+// longer exists.
+func (v *GaugeVec) Delete(values ...string) {
+	v.family.delete(values)
+}
+
+type HistogramVec struct {
+	family *family
+}
+
+// NewHistogram declares a histogram with the given bucket bounds, in
+// increasing order, and labels.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic(fmt.Sprintf("buckets of metric %s are not in order", name))
+	}
+	return &HistogramVec{r.register(name, help, "histogram", labels, func() metric { return newHistogram(buckets) })}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.family.with(values).(*Histogram)
+}
@@ -0,0 +1,119 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+
+	requests := r.NewCounter("requests_total", "Requests, by method and code.", "method", "code")
+	clients := r.NewGauge("clients", "Clients connected.")
+	sizes := r.NewHistogram("size_bytes", "Sizes.", []float64{10, 100}, "direction")
+
+	requests.With("GET", "200").Inc()
+	requests.With("GET", "200").Add(2)
+	requests.With("POST", "400").Inc()
+	requests.With("POST", "400").Add(-5)
+
+	clients.With().Inc()
+	clients.With().Inc()
+	clients.With().Dec()
+
+	sizes.With("in").Observe(5)
+	sizes.With("in").Observe(10)
+	sizes.With("in").Observe(50)
+	sizes.With("in").Observe(500)
+
+	var out bytes.Buffer
+	r.Write(&out)
+
+	want := strings.Join([]string{
+		"# HELP requests_total Requests, by method and code.",
+		"# TYPE requests_total counter",
+		`requests_total{method="GET",code="200"} 3`,
+		`requests_total{method="POST",code="400"} 1`,
+		"# HELP clients Clients connected.",
+		"# TYPE clients gauge",
+		"clients 1",
+		"# HELP size_bytes Sizes.",
+		"# TYPE size_bytes histogram",
+		`size_bytes_bucket{direction="in",le="10"} 2`,
+		`size_bytes_bucket{direction="in",le="100"} 3`,
+		`size_bytes_bucket{direction="in",le="+Inf"} 4`,
+		`size_bytes_sum{direction="in"} 565`,
+		`size_bytes_count{direction="in"} 4`,
+		"",
+	}, "\n")
+
+	if out.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	r := NewRegistry()
+
+	rooms := r.NewGauge("rooms", "Back\\slash\nand newline.", "room")
+	rooms.With("a\"b\\c\n").Set(2)
+
+	var out bytes.Buffer
+	r.Write(&out)
+
+	for _, line := range []string{
+		`# HELP rooms Back\\slash\nand newline.`,
+		`rooms{room="a\"b\\c\n"} 2`,
+	} {
+		if !strings.Contains(out.String(), line) {
+			t.Errorf("missing %s in\n%s", line, out.String())
+		}
+	}
+}
+
+func TestDelete(t *testing.T) {
+	r := NewRegistry()
+
+	clients := r.NewGauge("clients", "Clients, by room.", "room")
+	clients.With("a").Inc()
+	clients.With("b").Inc()
+	clients.Delete("a")
+
+	var out bytes.Buffer
+	r.Write(&out)
+
+	if strings.Contains(out.String(), `room="a"`) || !strings.Contains(out.String(), `room="b"`) {
+		t.Errorf("unexpected series in\n%s", out.String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("total", "Everything.").With().Inc()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := w.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", got)
+	}
+	if !strings.Contains(w.Body.String(), "total 1\n") {
+		t.Errorf("body %q", w.Body.String())
+	}
+}
+
+func TestDeclaredTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("total", "Everything.")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("declaring a metric twice did not panic")
+		}
+	}()
+
+	r.NewGauge("total", "Everything again.")
+}
@@ -0,0 +1,100 @@
+// Package origins decides which web origins may use the services: pages
+// embedding a board elsewhere need both CORS on the vector service and their
+// origin accepted by the websocket upgrader.
+package origins
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"backend/internal/logging"
+)
+
+// A List of allowed origins, such as "https://portal.example.com". "*"
+// allows every origin. Pages served from the services' own host are always
+// allowed.
+type List []string
+
+// Parse splits a comma separated list of origins.
+func Parse(value string) List {
+	list := List{}
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			list = append(list, strings.TrimSuffix(origin, "/"))
+		}
+	}
+	return list
+}
+
+// Allows reports whether the list names the origin.
+func (l List) Allows(origin string) bool {
+	for _, allowed := range l {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameHost(origin string, r *http.Request) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// CheckOrigin is for websocket.Upgrader. Like the upgrader's own check it
+// accepts requests without an Origin, which do not come from browsers, and
+// pages from the same host.
+func (l List) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || sameHost(origin, r) || l.Allows(origin) {
+		return true
+	}
+
+	logging.From(r.Context()).Warn("Refused websocket from origin", "origin", origin)
+	return false
+}
+
+// How long browsers may cache the answer to a preflight request.
+const preflightMaxAge = 10 * time.Minute
+
+// CORS answers preflight requests and marks responses as readable by the
+// allowed origins. It must come before anything that needs credentials,
+// since browsers send preflights without them.
+func CORS(l List) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" || sameHost(origin, r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			w.Header().Add("Vary", "Origin")
+			// Refused outright, not merely left unreadable: a form can POST
+			// across origins without a preflight.
+			if !l.Allows(origin) {
+				logging.From(r.Context()).Warn("Refused cross-origin request", "method", r.Method, "path", r.URL.Path, "origin", origin)
+				http.Error(w, "Origin not allowed.", http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+
+			if preflight {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(preflightMaxAge.Seconds())))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
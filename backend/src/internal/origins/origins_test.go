@@ -0,0 +1,91 @@
+package origins
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	list := Parse(" https://portal.example.com/, ,http://localhost:8080")
+	if len(list) != 2 || list[0] != "https://portal.example.com" {
+		t.Fatalf("Want two origins without trailing slashes; Got %q", list)
+	}
+
+	if !list.Allows("https://PORTAL.example.com") || list.Allows("https://evil.example.com") {
+		t.Errorf("Allows is wrong for %q", list)
+	}
+
+	if !Parse("*").Allows("https://anywhere.example.com") {
+		t.Errorf("Want * to allow every origin")
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	list := List{"https://portal.example.com"}
+
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"", true},
+		{"http://boards.example.com", true},
+		{"https://portal.example.com", true},
+		{"https://evil.example.com", false},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "http://boards.example.com/", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if got := list.CheckOrigin(r); got != test.want {
+			t.Errorf("Origin '%s': Want %v; Got %v", test.origin, test.want, got)
+		}
+	}
+}
+
+func TestCORS(t *testing.T) {
+	reached := false
+	handler := CORS(List{"https://portal.example.com"})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reached = true
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   bool
+		wantStatus  int
+		wantAllowed bool
+		wantReached bool
+	}{
+		{"same origin", "POST", "http://boards.example.com", false, http.StatusOK, false, true},
+		{"allowed", "POST", "https://portal.example.com", false, http.StatusOK, true, true},
+		{"allowed preflight", "OPTIONS", "https://portal.example.com", true, http.StatusNoContent, true, false},
+		{"refused", "POST", "https://evil.example.com", false, http.StatusForbidden, false, false},
+		{"refused preflight", "OPTIONS", "https://evil.example.com", true, http.StatusForbidden, false, false},
+	}
+
+	for _, test := range tests {
+		reached = false
+		r := httptest.NewRequest(test.method, "http://boards.example.com/", nil)
+		r.Header.Set("Origin", test.origin)
+		if test.preflight {
+			r.Header.Set("Access-Control-Request-Method", "POST")
+		}
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if w.Code != test.wantStatus {
+			t.Errorf("%s: Want status %d; Got %d", test.name, test.wantStatus, w.Code)
+		}
+		if allowed := w.Header().Get("Access-Control-Allow-Origin") == test.origin; allowed != test.wantAllowed {
+			t.Errorf("%s: Want allowed %v; Got %v", test.name, test.wantAllowed, allowed)
+		}
+		if reached != test.wantReached {
+			t.Errorf("%s: Want the handler reached %v; Got %v", test.name, test.wantReached, reached)
+		}
+	}
+}
@@ -1,14 +1,14 @@
 package vector
 
 type PostRequest struct {
-	CommandStore *CommandStore `json:"store, omitempty"`
-	CommandIndex *CommandIndex `json:"index, omitempty"`
+	CommandStore *CommandStore `json:"store"`
+	CommandIndex *CommandIndex `json:"index"`
 }
 
 type PostResponse struct {
 	Error       string       `json:"error,omitempty"`
-	ResultStore *ResultStore `json:"store, omitempty"`
-	ResultIndex *ResultIndex `json:"index, omitempty"`
+	ResultStore *ResultStore `json:"store"`
+	ResultIndex *ResultIndex `json:"index"`
 }
 
 type CommandStore struct {
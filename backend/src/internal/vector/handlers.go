@@ -4,15 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"path/filepath"
 	"strconv"
+
+	"backend/internal/logging"
 )
 
 const (
 	ErrorFailedToParse = "Failed to parse body."
 	ErrorMissingBody   = "Missing request body."
+	ErrorReservedName  = "Filename is reserved by the service."
 )
 
 func ApplyPostRequest(store Interface, request *PostRequest) PostResponse {
@@ -30,6 +32,10 @@ func ApplyPostRequest(store Interface, request *PostRequest) PostResponse {
 }
 
 func ApplyCommandStore(store Interface, cmd *CommandStore) *ResultStore {
+	if reservedNames[filepath.Base(cmd.Filename)] {
+		return &ResultStore{Error: ErrorReservedName}
+	}
+
 	if err := store.WriteJSON(cmd.Filename, cmd.Content); err != nil {
 		return &ResultStore{Error: err.Error()}
 	}
@@ -72,7 +78,7 @@ func PostHandler(store Interface) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		request, err := ParsePostRequest(r.Body)
 		if err != nil {
-			log.Printf("PostHandler Error: %s", err.Error())
+			logging.From(r.Context()).Info("Refused request", "err", err)
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -132,6 +132,16 @@ func TestPostCommandStore(t *testing.T) {
 	}
 }
 
+func TestPostCommandStoreReservedName(t *testing.T) {
+	for _, filename := range []string{"metrics", "healthz", "readyz", "placeholder.svg"} {
+		response := ApplyCommandStore(&MockStore{}, &CommandStore{Filename: filename, Content: "{}"})
+
+		if response.Error != ErrorReservedName {
+			t.Errorf("%s: Want the name refused; Got '%s'", filename, response.Error)
+		}
+	}
+}
+
 func TestPostCommandIndex(t *testing.T) {
 	want := []string{"file1.svg", "file2.svg", "file3.svg"}
 
@@ -0,0 +1,27 @@
+package vector
+
+import (
+	"net/http"
+	"strconv"
+
+	"backend/internal/metrics"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+var requests = metrics.Default.NewCounter("doodleopia_vector_requests_total",
+	"Requests to the vector service, by method and status code.", "method", "code")
+
+// countRequests counts every request, refused ones included.
+func countRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		requests.With(r.Method, strconv.Itoa(status)).Inc()
+	})
+}
@@ -0,0 +1,28 @@
+package vector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestCountRequests(t *testing.T) {
+	store := &Store{Directory: os.TempDir()}
+	handler := store.Handler()
+
+	ok := requests.With(http.MethodGet, "200")
+	bad := requests.With(http.MethodGet, "400")
+	okBefore, badBefore := ok.Value(), bad.Value()
+
+	for _, target := range []string{"/placeholder.svg?width=1&height=1", "/placeholder.svg"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, target, nil))
+	}
+
+	if got := ok.Value() - okBefore; got != 1 {
+		t.Errorf("Want 1 request answered 200; Got %v", got)
+	}
+	if got := bad.Value() - badBefore; got != 1 {
+		t.Errorf("Want 1 request answered 400; Got %v", got)
+	}
+}
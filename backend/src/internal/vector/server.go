@@ -5,36 +5,80 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"time"
+
+	"backend/internal/auth"
+	"backend/internal/certs"
+	"backend/internal/health"
+	"backend/internal/logging"
+	"backend/internal/metrics"
+	"backend/internal/origins"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
 
-func (store *Store) Serve(port int) {
+// Names the routes of Handler take from the stored files, which are served by
+// name alongside them. Storing a file under one is refused.
+var reservedNames = map[string]bool{
+	"healthz":         true,
+	"readyz":          true,
+	"metrics":         true,
+	"placeholder.svg": true,
+}
+
+// Handler serves the store, for mounting on a listener of someone else's.
+func (store *Store) Handler() http.Handler {
 	handler := chi.NewRouter()
 
+	handler.Use(logging.Middleware(store.logger()))
 	handler.Use(middleware.NoCache)
 
-	handler.Get("/*", GetHandler(store))
-	handler.Post("/", PostHandler(store))
+	// Ahead of routing, so that preflights are answered for every route.
+	handler.Use(origins.CORS(store.Origins))
+
+	health.Mount(handler, nil, store.readiness)
+
+	handler.Group(func(r chi.Router) {
+		r.Use(countRequests)
+		r.Use(auth.Middleware(store.Auth))
+
+		r.Get("/metrics", metrics.Handler().ServeHTTP)
+		r.Get("/*", GetHandler(store))
+		r.Post("/", PostHandler(store))
+	})
+
+	return handler
+}
+
+// readiness checks that drawings can be written to the store.
+func (store *Store) readiness() []health.Check {
+	return []health.Check{health.Writable(store.Directory)}
+}
+
+func (store *Store) Serve(port int) {
+	logger := store.logger()
 
 	addr := fmt.Sprintf(":%d", port)
-	server := &http.Server{Addr: addr, Handler: handler}
+	server := &http.Server{
+		Addr:      addr,
+		Handler:   store.Handler(),
+		TLSConfig: store.TLS,
+		ErrorLog:  log.New(logger.Writer(logging.LevelWarn), "", 0)}
+
 	go func() {
-		log.Printf("—VECTORSERVICE— serving HTTP on %s\n", addr)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Printf("—VECTORSERVICE— unexpected error: %v", err)
+		logger.Info("Serving", "addr", addr)
+		if err := certs.ListenAndServe(server); err != http.ErrServerClosed {
+			logger.Error("Failed to serve", "addr", addr, "err", err)
 		}
 	}()
 
 	<-store.Stop
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), store.ShutdownTimeout)
 
 	defer cancel()
 
 	if err := server.Shutdown(ctx); err != nil {
-		log.Printf("—VECTORSERVICE— shutdown error: %v", err)
+		logger.Error("Failed to shut down", "err", err)
 	}
 }
@@ -0,0 +1,36 @@
+package vector
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"backend/internal/auth"
+	"backend/internal/origins"
+)
+
+func TestPreflight(t *testing.T) {
+	store := &Store{
+		Directory: os.TempDir(),
+		Origins:   origins.List{"https://portal.example.com"},
+		Auth:      &auth.Password{Password: "secret"}}
+	handler := store.Handler()
+
+	for _, target := range []string{"/", "/drawing.json"} {
+		request := httptest.NewRequest(http.MethodOptions, target, nil)
+		request.Header.Set("Origin", "https://portal.example.com")
+		request.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		request.Header.Set("Access-Control-Request-Headers", "authorization, content-type")
+
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+
+		if response.Code != http.StatusNoContent {
+			t.Errorf("%s: Want the preflight answered without credentials; Got %d", target, response.Code)
+		}
+		if got := response.Header().Get("Access-Control-Allow-Origin"); got != "https://portal.example.com" {
+			t.Errorf("%s: Want the origin allowed; Got '%s'", target, got)
+		}
+	}
+}
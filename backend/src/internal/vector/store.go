@@ -1,11 +1,16 @@
 package vector
 
 import (
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
+	"time"
+
+	"backend/internal/auth"
+	"backend/internal/logging"
+	"backend/internal/origins"
 )
 
 type Interface interface {
@@ -17,6 +22,26 @@ type Interface interface {
 type Store struct {
 	Directory string
 	Stop      chan struct{}
+
+	// Authenticates every request, or nil to let anyone in.
+	Auth auth.Authenticator
+
+	// Other origins whose pages may call the service.
+	Origins origins.List
+
+	// How long Serve waits, once Stop is closed, for requests to finish.
+	// Zero waits for nothing.
+	ShutdownTimeout time.Duration
+
+	// Serves HTTPS if set, see certs.
+	TLS *tls.Config
+
+	// Where the service logs, or nil for logging.Default.
+	Log *logging.Logger
+}
+
+func (s *Store) logger() *logging.Logger {
+	return s.Log.With("component", "vector")
 }
 
 func (s *Store) CreateStore(parts ...string) (string, error) {
@@ -29,7 +54,7 @@ func (s *Store) CreateStore(parts ...string) (string, error) {
 func (s *Store) GetIndex() ([]string, error) {
 	files, err := ioutil.ReadDir(s.Directory)
 	if err != nil {
-		log.Printf("ReadDir returned an error: %v", err)
+		s.logger().Error("Failed to index the store", "err", err)
 		return []string{}, fmt.Errorf("Failed to index the store.")
 	}
 
@@ -63,7 +88,7 @@ func (store *Store) WriteJSON(filename, content string) error {
 		return fmt.Errorf("Failed to write JSON to file: %s", err.Error())
 	}
 
-	log.Printf("Wrote JSON file (%s) to disk, sized %d bytes.", filename, cnt)
+	store.logger().Debug("Wrote JSON", "file", filename, "bytes", cnt)
 
 	f.Sync()
 	f.Close()
@@ -7,6 +7,7 @@ export type CursorTrackerEventHandler = {
 
 interface CursorTrackerInterface {
   updateCursor: (clientId: string, Coordinate) => void;
+  removeCursor: (clientId: string) => void;
 }
 
 export default class CursorTracker implements CursorTrackerInterface {
@@ -39,6 +40,14 @@ export default class CursorTracker implements CursorTrackerInterface {
       this.eventHandler.onNewCursor(cursor);
     }
   }
+
+  removeCursor(clientId) {
+    const cursor = this.cursors[clientId];
+    if (cursor) {
+      delete this.cursors[clientId];
+      this.eventHandler.onDeadCursor(cursor);
+    }
+  }
 }
 
 function CreateCursor(): SVGElement {
@@ -183,6 +183,13 @@ function processMessage(message: Message) {
       const { clientId, data } = message;
       cursorTracker.updateCursor(clientId, data);
       break;
+    case MessageAction.Welcome:
+      // Messages keeps the id the server assigned.
+      break;
+    case MessageAction.Leave:
+      // The client has gone, so its cursor will not move again.
+      cursorTracker.removeCursor(message.clientId);
+      break;
     default:
       console.log(`Unknown message action: ${action}`);
   }
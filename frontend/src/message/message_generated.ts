@@ -11,7 +11,13 @@ export enum Action{
   Move= 2,
   Clear= 3,
   Cursor= 4,
-  Cancel= 5
+  Cancel= 5,
+  Polyline= 6,
+  Welcome= 7,
+  Leave= 8,
+  Undo= 9,
+  Redo= 10,
+  Erase= 11
 }};
 
 /**
@@ -60,6 +66,101 @@ static createCoordinate(builder:flatbuffers.Builder, x: number, y: number):flatb
   return builder.offset();
 };
 
+}
+}
+/**
+ * @constructor
+ */
+export namespace message{
+export class Polyline {
+  bb: flatbuffers.ByteBuffer|null = null;
+
+  bb_pos:number = 0;
+/**
+ * @param number i
+ * @param flatbuffers.ByteBuffer bb
+ * @returns Polyline
+ */
+__init(i:number, bb:flatbuffers.ByteBuffer):Polyline {
+  this.bb_pos = i;
+  this.bb = bb;
+  return this;
+};
+
+/**
+ * @param flatbuffers.ByteBuffer bb
+ * @param Polyline= obj
+ * @returns Polyline
+ */
+static getRootAsPolyline(bb:flatbuffers.ByteBuffer, obj?:Polyline):Polyline {
+  return (obj || new Polyline()).__init(bb.readInt32(bb.position()) + bb.position(), bb);
+};
+
+/**
+ * @param flatbuffers.ByteBuffer bb
+ * @param Polyline= obj
+ * @returns Polyline
+ */
+static getSizePrefixedRootAsPolyline(bb:flatbuffers.ByteBuffer, obj?:Polyline):Polyline {
+  bb.setPosition(bb.position() + flatbuffers.SIZE_PREFIX_LENGTH);
+  return (obj || new Polyline()).__init(bb.readInt32(bb.position()) + bb.position(), bb);
+};
+
+/**
+ * @param number index
+ * @param message.Coordinate= obj
+ * @returns message.Coordinate
+ */
+points(index: number, obj?:message.Coordinate):message.Coordinate|null {
+  var offset = this.bb!.__offset(this.bb_pos, 4);
+  return offset ? (obj || new message.Coordinate()).__init(this.bb!.__vector(this.bb_pos + offset) + index * 8, this.bb!) : null;
+};
+
+/**
+ * @returns number
+ */
+pointsLength():number {
+  var offset = this.bb!.__offset(this.bb_pos, 4);
+  return offset ? this.bb!.__vector_len(this.bb_pos + offset) : 0;
+};
+
+/**
+ * @param flatbuffers.Builder builder
+ */
+static startPolyline(builder:flatbuffers.Builder) {
+  builder.startObject(1);
+};
+
+/**
+ * @param flatbuffers.Builder builder
+ * @param flatbuffers.Offset pointsOffset
+ */
+static addPoints(builder:flatbuffers.Builder, pointsOffset:flatbuffers.Offset) {
+  builder.addFieldOffset(0, pointsOffset, 0);
+};
+
+/**
+ * @param flatbuffers.Builder builder
+ * @param number numElems
+ */
+static startPointsVector(builder:flatbuffers.Builder, numElems:number) {
+  builder.startVector(8, numElems, 4);
+};
+
+/**
+ * @param flatbuffers.Builder builder
+ * @returns flatbuffers.Offset
+ */
+static endPolyline(builder:flatbuffers.Builder):flatbuffers.Offset {
+  var offset = builder.endObject();
+  return offset;
+};
+
+static createPolyline(builder:flatbuffers.Builder, pointsOffset:flatbuffers.Offset):flatbuffers.Offset {
+  Polyline.startPolyline(builder);
+  Polyline.addPoints(builder, pointsOffset);
+  return Polyline.endPolyline(builder);
+}
 }
 }
 /**
@@ -133,11 +234,36 @@ action():message.Action {
   return offset ? /**  */ (this.bb!.readInt8(this.bb_pos + offset)) : message.Action.Up;
 };
 
+/**
+ * @returns flatbuffers.Long
+ */
+sequence():flatbuffers.Long {
+  var offset = this.bb!.__offset(this.bb_pos, 12);
+  return offset ? this.bb!.readUint64(this.bb_pos + offset) : this.bb!.createLong(0, 0);
+};
+
+/**
+ * @param message.Polyline= obj
+ * @returns message.Polyline|null
+ */
+polyline(obj?:message.Polyline):message.Polyline|null {
+  var offset = this.bb!.__offset(this.bb_pos, 14);
+  return offset ? (obj || new message.Polyline()).__init(this.bb!.__indirect(this.bb_pos + offset), this.bb!) : null;
+};
+
+/**
+ * @returns number
+ */
+target():number {
+  var offset = this.bb!.__offset(this.bb_pos, 16);
+  return offset ? this.bb!.readUint16(this.bb_pos + offset) : 0;
+};
+
 /**
  * @param flatbuffers.Builder builder
  */
 static startMessage(builder:flatbuffers.Builder) {
-  builder.startObject(4);
+  builder.startObject(7);
 };
 
 /**
@@ -172,6 +298,30 @@ static addAction(builder:flatbuffers.Builder, action:message.Action) {
   builder.addFieldInt8(3, action, message.Action.Up);
 };
 
+/**
+ * @param flatbuffers.Builder builder
+ * @param flatbuffers.Long sequence
+ */
+static addSequence(builder:flatbuffers.Builder, sequence:flatbuffers.Long) {
+  builder.addFieldInt64(4, sequence, builder.createLong(0, 0));
+};
+
+/**
+ * @param flatbuffers.Builder builder
+ * @param flatbuffers.Offset polylineOffset
+ */
+static addPolyline(builder:flatbuffers.Builder, polylineOffset:flatbuffers.Offset) {
+  builder.addFieldOffset(5, polylineOffset, 0);
+};
+
+/**
+ * @param flatbuffers.Builder builder
+ * @param number target
+ */
+static addTarget(builder:flatbuffers.Builder, target:number) {
+  builder.addFieldInt16(6, target, 0);
+};
+
 /**
  * @param flatbuffers.Builder builder
  * @returns flatbuffers.Offset
@@ -197,12 +347,15 @@ static finishSizePrefixedMessageBuffer(builder:flatbuffers.Builder, offset:flatb
   builder.finish(offset, undefined, true);
 };
 
-static createMessage(builder:flatbuffers.Builder, clientId:number, id:number, dataOffset:flatbuffers.Offset, action:message.Action):flatbuffers.Offset {
+static createMessage(builder:flatbuffers.Builder, clientId:number, id:number, dataOffset:flatbuffers.Offset, action:message.Action, sequence:flatbuffers.Long, polylineOffset:flatbuffers.Offset, target:number):flatbuffers.Offset {
   Message.startMessage(builder);
   Message.addClientId(builder, clientId);
   Message.addId(builder, id);
   Message.addData(builder, dataOffset);
   Message.addAction(builder, action);
+  Message.addSequence(builder, sequence);
+  Message.addPolyline(builder, polylineOffset);
+  Message.addTarget(builder, target);
   return Message.endMessage(builder);
 }
 }
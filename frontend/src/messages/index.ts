@@ -1,5 +1,9 @@
 import type { Coordinate } from "../coordinates";
-import Message, { buildFlatbuffer, readFlatbuffer } from "../message";
+import Message, {
+  MessageAction,
+  buildFlatbuffer,
+  readFlatbuffer,
+} from "../message";
 
 export type MessagesEventHandler = {
   onOpen: () => void;
@@ -16,7 +20,8 @@ interface MessagesInterface {
 }
 
 export default class implements MessagesInterface {
-  clientId: number = Math.floor(Math.random() * 65535);
+  // Assigned by the server, which welcomes each connection with its id.
+  clientId: number = 0;
   eventHandler: MessagesEventHandler;
   conn: WebSocket;
   timerId: number | undefined;
@@ -46,6 +51,7 @@ export default class implements MessagesInterface {
 
     this.conn.onclose = (evt) => {
       this.conn = undefined;
+      this.clientId = 0;
       this.timerId = window.setTimeout(() => this.open(), 250);
       this.eventHandler.onClose();
     };
@@ -68,8 +74,11 @@ export default class implements MessagesInterface {
 
   receive(incoming: ArrayBuffer) {
     const buf = new Uint8Array(incoming);
+    const message = readFlatbuffer(buf);
+    if (message.action === MessageAction.Welcome) {
+      this.clientId = message.clientId;
+    }
     if (this.eventHandler.onMessage) {
-      const message = readFlatbuffer(buf);
       this.eventHandler.onMessage(message);
     }
   }